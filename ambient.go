@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// ambientApiKeyEnv is the environment variable holding the ambient API key.
+const ambientApiKeyEnv = "DYNU_API_KEY"
+
+// ambientCredentials is the default Dynu API key of the webhook, used for
+// challenges that reference no secret when cert-manager allows ambient
+// credentials. The key is read from a file, which is re-read whenever it
+// changes, or else from an environment variable.
+type ambientCredentials struct {
+	// file is the path of the file holding the API key. Empty uses value.
+	file string
+	// value is the API key taken from the environment.
+	value string
+	// onRotate is called with the old API key when the file changed.
+	onRotate func(oldValue string)
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+}
+
+// newAmbientCredentials returns the ambient credentials read from file or,
+// if file is empty, from the environment variable env. It returns nil when
+// neither provides an API key.
+func newAmbientCredentials(file string, env string, onRotate func(oldValue string)) *ambientCredentials {
+	if file != "" {
+		return &ambientCredentials{file: file, onRotate: onRotate}
+	}
+	if value := strings.TrimSpace(os.Getenv(env)); value != "" {
+		return &ambientCredentials{value: value}
+	}
+	return nil
+}
+
+// ApiKey returns the current ambient API key.
+func (a *ambientCredentials) ApiKey() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == "" {
+		return a.value, nil
+	}
+
+	info, err := os.Stat(a.file)
+	if err != nil {
+		return "", fmt.Errorf("unable to read ambient API key: %v", err)
+	}
+	if info.ModTime().Equal(a.modTime) && info.Size() == a.size && a.value != "" {
+		return a.value, nil
+	}
+	data, err := os.ReadFile(a.file)
+	if err != nil {
+		return "", fmt.Errorf("unable to read ambient API key: %v", err)
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", fmt.Errorf("ambient API key file %s is empty", a.file)
+	}
+	if a.value != "" && value != a.value {
+		klog.Infof("Ambient API key in %s changed, dropping cached Dynu data of the old key", a.file)
+		if a.onRotate != nil {
+			a.onRotate(a.value)
+		}
+	}
+	a.value = value
+	a.modTime = info.ModTime()
+	a.size = info.Size()
+	return value, nil
+}
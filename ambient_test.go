@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAmbientCredentials_File(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "api-key")
+	assert.NoError(t, os.WriteFile(file, []byte("old-key\n"), 0o600))
+
+	var rotated []string
+	ambient := newAmbientCredentials(file, "DYNU_TEST_API_KEY", func(oldValue string) {
+		rotated = append(rotated, oldValue)
+	})
+	apiKey, err := ambient.ApiKey()
+	assert.NoError(t, err)
+	assert.Equal(t, "old-key", apiKey)
+
+	assert.NoError(t, os.WriteFile(file, []byte("new-key\n"), 0o600))
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(file, later, later))
+	apiKey, err = ambient.ApiKey()
+	assert.NoError(t, err)
+	assert.Equal(t, "new-key", apiKey)
+	assert.Equal(t, []string{"old-key"}, rotated)
+
+	assert.NoError(t, os.Remove(file))
+	_, err = ambient.ApiKey()
+	assert.Error(t, err)
+}
+
+func TestAmbientCredentials_Env(t *testing.T) {
+	assert.Nil(t, newAmbientCredentials("", "DYNU_TEST_API_KEY", nil))
+
+	t.Setenv("DYNU_TEST_API_KEY", "env-key")
+	apiKey, err := newAmbientCredentials("", "DYNU_TEST_API_KEY", nil).ApiKey()
+	assert.NoError(t, err)
+	assert.Equal(t, "env-key", apiKey)
+}
+
+func TestSolver_AmbientApiKey(t *testing.T) {
+	solver := newDynuDNSProviderSolver()
+	ch := &v1alpha1.ChallengeRequest{ResourceNamespace: "cert-manager"}
+
+	// Neither a secret nor ambient credentials allowed.
+	_, err := solver.apiKey(context.Background(), dynuDNSProviderConfig{}, ch)
+	assert.ErrorContains(t, err, "ambient credentials are not allowed")
+
+	// Allowed, but the webhook has no ambient API key.
+	ch.AllowAmbientCredentials = true
+	_, err = solver.apiKey(context.Background(), dynuDNSProviderConfig{}, ch)
+	assert.ErrorContains(t, err, "no ambient API key")
+
+	solver.ambient = &ambientCredentials{value: "ambient-key"}
+	apiKey, err := solver.apiKey(context.Background(), dynuDNSProviderConfig{}, ch)
+	assert.NoError(t, err)
+	assert.Equal(t, "ambient-key", apiKey)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+
+	"github.com/Dopingus/cert-manager-webhook-dynu/dynu"
+)
+
+// solverConfigVersion is the current version of the solver config format. A
+// config without version is read as this version.
+const solverConfigVersion = "v1"
+
+// configErrors collects the problems of a solver config, so that they can be
+// reported all at once.
+type configErrors []string
+
+func (e *configErrors) add(field string, format string, args ...interface{}) {
+	*e = append(*e, field+": "+fmt.Sprintf(format, args...))
+}
+
+// without returns the problems that are not about the fields paths or fields
+// below them.
+func (e configErrors) without(paths []string) configErrors {
+	var kept configErrors
+	for _, problem := range e {
+		field, _, _ := strings.Cut(problem, ": ")
+		below := false
+		for _, path := range paths {
+			if field == path || strings.HasPrefix(field, path+".") || strings.HasPrefix(field, path+"[") {
+				below = true
+				break
+			}
+		}
+		if !below {
+			kept = append(kept, problem)
+		}
+	}
+	return kept
+}
+
+// err returns the collected problems as a single error, or nil.
+func (e configErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid solver config: %s", strings.Join(e, "; "))
+}
+
+// loadConfig is a small helper function that decodes JSON configuration into
+// the typed config struct. Unknown fields, including fields differing from a
+// known one only in case, are rejected and all invalid fields are reported in
+// one error.
+func loadConfig(cfgJSON *extapi.JSON) (dynuDNSProviderConfig, error) {
+	cfg := dynuDNSProviderConfig{}
+	// handle the 'base case' where no configuration has been provided
+	if cfgJSON == nil {
+		return cfg, nil
+	}
+
+	// Numbers are kept as literals, so that checkFields rejects 1.0 for an
+	// int just like encoding/json does.
+	var raw interface{}
+	dec := json.NewDecoder(bytes.NewReader(cfgJSON.Raw))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return cfg, fmt.Errorf("error decoding solver config: %v", err)
+	}
+	if _, ok := raw.(map[string]interface{}); !ok {
+		return cfg, fmt.Errorf("error decoding solver config: expected a JSON object")
+	}
+
+	problems := configErrors{}
+	mistyped := checkFields(&problems, "", raw, reflect.TypeOf(cfg))
+	if err := json.Unmarshal(cfgJSON.Raw, &cfg); err != nil {
+		typeErr := &json.UnmarshalTypeError{}
+		if !errors.As(err, &typeErr) {
+			return cfg, fmt.Errorf("error decoding solver config: %v", err)
+		}
+		// encoding/json only reports the first type mismatch, which
+		// checkFields has normally reported along with all others.
+		if !containsField(mistyped, typeErr.Field) {
+			problems.add(typeErr.Field, "must be of type %s, got %s", typeErr.Type, typeErr.Value)
+		}
+	}
+	// Fields of the wrong type are left unset; problems following from that
+	// would only repeat the type mismatch.
+	invalid := configErrors{}
+	cfg.validate(&invalid)
+	problems = append(problems, invalid.without(mistyped)...)
+	return cfg, problems.err()
+}
+
+// validate adds the problems of the decoded config cfg to problems.
+func (cfg dynuDNSProviderConfig) validate(problems *configErrors) {
+	if cfg.Version != "" && cfg.Version != solverConfigVersion {
+		problems.add("version", "unsupported version %q, expected %q", cfg.Version, solverConfigVersion)
+	}
+	validateSecretRef(problems, "", cfg.SecretRef, cfg.ApiKeySecretRef)
+	validateZones(problems, cfg.Zones)
+	if cfg.DomainId < 0 {
+		problems.add("domainId", "must not be negative, got %d", cfg.DomainId)
+	}
+	if name := normalizeZone(cfg.ZoneName); strings.ContainsAny(name, " \t*") || strings.HasPrefix(name, ".") {
+		problems.add("zoneName", "%q must be a domain name", cfg.ZoneName)
+	}
+	if cfg.ApiUrl != "" {
+		if err := dynu.ValidateApiUrl(cfg.ApiUrl); err != nil {
+			problems.add("apiUrl", "%v", err)
+		}
+	}
+	if name := cfg.MirrorRecord.Name; strings.HasSuffix(name, ".") || strings.ContainsAny(name, " \t") {
+		problems.add("mirrorRecord.name", "%q must be a node name relative to the zone, or \"@\" for the apex", name)
+	}
+	if cfg.Ttl != 0 && (cfg.Ttl < dynu.MinTtl || cfg.Ttl > dynu.MaxTtl) {
+		problems.add("ttl", "%d is outside the range %d to %d seconds allowed by Dynu", cfg.Ttl, dynu.MinTtl, dynu.MaxTtl)
+	}
+}
+
+// validateSecretRef checks the secretName and apiKeySecretRef fields of the
+// solver config, or of its entry prefix.
+func validateSecretRef(problems *configErrors, prefix string, secretName string, ref *secretKeySelector) {
+	if ref == nil {
+		return
+	}
+	if secretName != "" {
+		problems.add(prefix+"apiKeySecretRef", "must not be set together with %ssecretName", prefix)
+	}
+	if ref.Name == "" {
+		problems.add(prefix+"apiKeySecretRef.name", "is required")
+	}
+}
+
+// checkFields adds every key of the decoded JSON value raw that has no field
+// in type t, and every value that does not fit the type of its field, to
+// problems. Unlike encoding/json, keys are matched case sensitively, so that a
+// misspelt secretname is not silently accepted. It returns the paths of the
+// values of the wrong type.
+func checkFields(problems *configErrors, path string, raw interface{}, t reflect.Type) (mistyped []string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if raw == nil {
+		// null leaves the field unset.
+		return nil
+	}
+	if got, ok := jsonTypeFits(raw, t); !ok {
+		problems.add(path, "must be of type %s, got %s", jsonTypeName(t), got)
+		return []string{path}
+	}
+	switch value := raw.(type) {
+	case map[string]interface{}:
+		if t.Kind() != reflect.Struct {
+			return nil
+		}
+		fields := jsonFields(t)
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fieldPath := key
+			if path != "" {
+				fieldPath = path + "." + key
+			}
+			fieldType, ok := fields[key]
+			if !ok {
+				problems.add(fieldPath, "unknown field%s", didYouMean(key, fields))
+				continue
+			}
+			mistyped = append(mistyped, checkFields(problems, fieldPath, value[key], fieldType)...)
+		}
+	case []interface{}:
+		for i, item := range value {
+			mistyped = append(mistyped, checkFields(problems, fmt.Sprintf("%s[%d]", path, i), item, t.Elem())...)
+		}
+	}
+	return mistyped
+}
+
+// jsonTypeFits reports whether the decoded JSON value raw fits type t. It
+// also returns the JSON type of raw for messages.
+func jsonTypeFits(raw interface{}, t reflect.Type) (string, bool) {
+	switch value := raw.(type) {
+	case string:
+		return "string", t.Kind() == reflect.String || t.Kind() == reflect.Interface
+	case bool:
+		return "bool", t.Kind() == reflect.Bool || t.Kind() == reflect.Interface
+	case json.Number:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if _, err := strconv.ParseInt(string(value), 10, t.Bits()); err != nil {
+				return fmt.Sprintf("number %s", value), false
+			}
+			return "number", true
+		case reflect.Float32, reflect.Float64, reflect.Interface:
+			return "number", true
+		}
+		return "number", false
+	case []interface{}:
+		return "array", t.Kind() == reflect.Slice || t.Kind() == reflect.Interface
+	case map[string]interface{}:
+		return "object", t.Kind() == reflect.Struct || t.Kind() == reflect.Map || t.Kind() == reflect.Interface
+	}
+	return fmt.Sprintf("%T", raw), false
+}
+
+// arrayIndex matches the array indices in the paths of checkFields, which
+// encoding/json leaves out of its field paths.
+var arrayIndex = regexp.MustCompile(`\[\d+\]`)
+
+// containsField reports whether field, as reported by encoding/json, is one
+// of the paths of checkFields.
+func containsField(paths []string, field string) bool {
+	for _, path := range paths {
+		if arrayIndex.ReplaceAllString(path, "") == field {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonTypeName returns the name of type t in messages.
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Slice:
+		return "array"
+	}
+	return t.Kind().String()
+}
+
+// jsonFields returns the types of the fields of struct type t by JSON name.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// didYouMean suggests the field that key differs from only in case.
+func didYouMean(key string, fields map[string]reflect.Type) string {
+	for name := range fields {
+		if strings.EqualFold(name, key) {
+			return fmt.Sprintf(", did you mean %q?", name)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+
+	"github.com/Dopingus/cert-manager-webhook-dynu/dynu"
+)
+
+func TestLoadConfig_ApiUrl(t *testing.T) {
+	cfg, err := loadConfig(&extapi.JSON{Raw: []byte(`{"secretName":"dynu-secret","apiUrl":"http://dynu-stub.test.svc:8080/v2"}`)})
+	assert.NoError(t, err)
+	assert.Equal(t, "dynu-secret", cfg.SecretRef)
+	assert.Equal(t, "http://dynu-stub.test.svc:8080/v2", cfg.ApiUrl)
+
+	_, err = loadConfig(&extapi.JSON{Raw: []byte(`{"secretName":"dynu-secret","apiUrl":"dynu-stub:8080"}`)})
+	assert.Error(t, err)
+}
+
+func TestLoadConfig_MirrorRecord(t *testing.T) {
+	cfg, err := loadConfig(&extapi.JSON{Raw: []byte(`{"secretName":"dynu-secret","mirrorRecord":{"enabled":false}}`)})
+	assert.NoError(t, err)
+	assert.False(t, *cfg.MirrorRecord.Enabled)
+
+	_, err = loadConfig(&extapi.JSON{Raw: []byte(`{"secretName":"dynu-secret","mirrorRecord":{"name":"mirror.example.com."}}`)})
+	assert.Error(t, err)
+}
+
+func TestLoadConfig_RecordSettings(t *testing.T) {
+	cfg, err := loadConfig(&extapi.JSON{Raw: []byte(`{"secretName":"dynu-secret","ttl":120,"state":true,"group":"acme"}`)})
+	assert.NoError(t, err)
+	assert.Equal(t, dynu.TxtRecordRequest{NodeName: "_acme-challenge", Ttl: "120", Group: "acme", State: "true", TextData: "key"}, cfg.txtRecordRequest("_acme-challenge", "key"))
+
+	cfg, err = loadConfig(&extapi.JSON{Raw: []byte(`{"secretName":"dynu-secret"}`)})
+	assert.NoError(t, err)
+	assert.Equal(t, dynu.TxtRecordRequest{NodeName: "_acme-challenge", Ttl: "60", Group: "", State: "true", TextData: "key"}, cfg.txtRecordRequest("_acme-challenge", "key"))
+
+	for _, ttl := range []string{"10", "-60", "100000"} {
+		_, err = loadConfig(&extapi.JSON{Raw: []byte(`{"secretName":"dynu-secret","ttl":` + ttl + `}`)})
+		assert.Error(t, err, ttl)
+	}
+}
+
+func TestLoadConfig_ApiKeySecretRef(t *testing.T) {
+	cfg, err := loadConfig(&extapi.JSON{Raw: []byte(`{"secretName":"dynu-secret"}`)})
+	assert.NoError(t, err)
+	assert.Equal(t, secretKeySelector{Name: "dynu-secret", Key: "api-key", Namespace: "cert-manager"}, cfg.apiKeySecret("cert-manager"))
+
+	cfg, err = loadConfig(&extapi.JSON{Raw: []byte(`{"apiKeySecretRef":{"name":"dynu-credentials","key":"token"}}`)})
+	assert.NoError(t, err)
+	assert.Equal(t, secretKeySelector{Name: "dynu-credentials", Key: "token", Namespace: "cert-manager"}, cfg.apiKeySecret("cert-manager"))
+
+	cfg, err = loadConfig(&extapi.JSON{Raw: []byte(`{"apiKeySecretRef":{"name":"dynu-credentials","namespace":"dns"}}`)})
+	assert.NoError(t, err)
+	assert.Equal(t, secretKeySelector{Name: "dynu-credentials", Key: "api-key", Namespace: "dns"}, cfg.apiKeySecret("cert-manager"))
+
+	_, err = loadConfig(&extapi.JSON{Raw: []byte(`{"apiKeySecretRef":{"key":"token"}}`)})
+	assert.Error(t, err)
+
+	_, err = loadConfig(&extapi.JSON{Raw: []byte(`{"secretName":"dynu-secret","apiKeySecretRef":{"name":"dynu-credentials"}}`)})
+	assert.Error(t, err)
+}
+
+func TestLoadConfig_Zones(t *testing.T) {
+	cfg, err := loadConfig(&extapi.JSON{Raw: []byte(`{
+		"secretName": "default-account",
+		"zones": [
+			{"zone": "example.com", "secretName": "account-a"},
+			{"zone": "Sub.Example.com.", "apiKeySecretRef": {"name": "account-b", "key": "token"}},
+			{"zone": "example.org", "apiKeySecretRef": {"name": "account-c", "namespace": "dns"}}
+		]
+	}`)})
+	assert.NoError(t, err)
+
+	for fqdn, want := range map[string]secretKeySelector{
+		"_acme-challenge.example.com.":         {Name: "account-a", Key: "api-key", Namespace: "cert-manager"},
+		"_acme-challenge.www.sub.example.com.": {Name: "account-b", Key: "token", Namespace: "cert-manager"},
+		"_acme-challenge.sub.example.com.":     {Name: "account-b", Key: "token", Namespace: "cert-manager"},
+		"_acme-challenge.notexample.com.":      {Name: "default-account", Key: "api-key", Namespace: "cert-manager"},
+		"_acme-challenge.example.org.":         {Name: "account-c", Key: "api-key", Namespace: "dns"},
+		"_acme-challenge.example.net.":         {Name: "default-account", Key: "api-key", Namespace: "cert-manager"},
+	} {
+		assert.Equal(t, want, cfg.forFQDN(fqdn).apiKeySecret("cert-manager"), fqdn)
+	}
+
+	for _, raw := range []string{
+		`{"zones":[{"zone":"","secretName":"a"}]}`,
+		`{"zones":[{"zone":"example.com"}]}`,
+		`{"zones":[{"zone":"example.com","secretName":"a"},{"zone":"EXAMPLE.com.","secretName":"b"}]}`,
+		`{"zones":[{"zone":"example.com","secretName":"a","apiKeySecretRef":{"name":"b"}}]}`,
+	} {
+		_, err := loadConfig(&extapi.JSON{Raw: []byte(raw)})
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestLoadConfig_Strict(t *testing.T) {
+	cfg, err := loadConfig(&extapi.JSON{Raw: []byte(`{"version":"v1","secretName":"dynu-secret"}`)})
+	assert.NoError(t, err)
+	assert.Equal(t, "dynu-secret", cfg.SecretRef)
+
+	_, err = loadConfig(&extapi.JSON{Raw: []byte(`{"secretname":"dynu-secret"}`)})
+	assert.EqualError(t, err, `invalid solver config: secretname: unknown field, did you mean "secretName"?`)
+
+	_, err = loadConfig(&extapi.JSON{Raw: []byte(`{
+		"version": "v2",
+		"secretName": "dynu-secret",
+		"ttl": 5,
+		"mirrorRecord": {"enable": false},
+		"zones": [{"zone": "example.com", "apiKeySecretRef": {"key": "token"}, "secret": "x"}]
+	}`)})
+	assert.EqualError(t, err, "invalid solver config: "+
+		"mirrorRecord.enable: unknown field; "+
+		"zones[0].secret: unknown field; "+
+		`version: unsupported version "v2", expected "v1"; `+
+		"zones[0].apiKeySecretRef.name: is required; "+
+		"ttl: 5 is outside the range 30 to 86400 seconds allowed by Dynu")
+
+	_, err = loadConfig(&extapi.JSON{Raw: []byte(`{"secretName":"dynu-secret","ttl":"60"}`)})
+	assert.EqualError(t, err, "invalid solver config: ttl: must be of type int, got string")
+
+	_, err = loadConfig(&extapi.JSON{Raw: []byte(`{
+		"secretName": "s",
+		"ttl": "60",
+		"domainId": 1.5,
+		"state": "yes",
+		"mirrorRecord": {"enabled": 1},
+		"zones": [{"zone": "example.com", "secretName": "s", "apiKeySecretRef": "x"}, "example.org"]
+	}`)})
+	assert.EqualError(t, err, "invalid solver config: "+
+		"domainId: must be of type int, got number 1.5; "+
+		"mirrorRecord.enabled: must be of type bool, got number; "+
+		"state: must be of type bool, got string; "+
+		"ttl: must be of type int, got string; "+
+		"zones[0].apiKeySecretRef: must be of type object, got string; "+
+		"zones[1]: must be of type object, got string")
+
+	_, err = loadConfig(&extapi.JSON{Raw: []byte(`{"secretName":"s","ttl":"60","domainId":"5","state":"yes"}`)})
+	assert.EqualError(t, err, "invalid solver config: "+
+		"domainId: must be of type int, got string; "+
+		"state: must be of type bool, got string; "+
+		"ttl: must be of type int, got string")
+
+	// encoding/json rejects 1.0 for an int; it must not be lost next to
+	// other problems.
+	_, err = loadConfig(&extapi.JSON{Raw: []byte(`{"secretname":"s","ttl":1.0,"domainId":1e20}`)})
+	assert.EqualError(t, err, "invalid solver config: "+
+		"domainId: must be of type int, got number 1e20; "+
+		`secretname: unknown field, did you mean "secretName"?; `+
+		"ttl: must be of type int, got number 1.0")
+
+	_, err = loadConfig(&extapi.JSON{Raw: []byte(`["dynu-secret"]`)})
+	assert.Error(t, err)
+}
+
+func TestLoadConfig_PinnedDomain(t *testing.T) {
+	cfg, err := loadConfig(&extapi.JSON{Raw: []byte(`{"secretName":"dynu-secret","domainId":123,"zoneName":"example.com"}`)})
+	assert.NoError(t, err)
+	assert.Equal(t, 123, cfg.DomainId)
+	assert.Equal(t, "example.com", cfg.ZoneName)
+
+	_, err = loadConfig(&extapi.JSON{Raw: []byte(`{"secretName":"dynu-secret","domainId":-1,"zoneName":"*.example.com"}`)})
+	assert.ErrorContains(t, err, "domainId: must not be negative")
+	assert.ErrorContains(t, err, "zoneName:")
+}
@@ -0,0 +1,242 @@
+// Package dynu contains a small client for the parts of the Dynu DNS API
+// (https://www.dynu.com/en-US/Support/API) used by the webhook.
+package dynu
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/klog"
+)
+
+const (
+	DefaultApiUrl = "https://api.dynu.com/v2"
+
+	// MinTtl and MaxTtl are the record TTLs in seconds accepted by Dynu.
+	MinTtl = 30
+	MaxTtl = 86400
+)
+
+// Interface is the set of Dynu API operations needed to solve a DNS01
+// challenge.
+type Interface interface {
+	// GetRoot returns the domain and node name Dynu resolves hostname to.
+	GetRoot(ctx context.Context, hostname string) (DNSRootResponse, error)
+	// GetDomains returns all domains associated with the API key.
+	GetDomains(ctx context.Context) ([]Domain, error)
+	// GetDomain returns a single domain.
+	GetDomain(ctx context.Context, domainId int) (Domain, error)
+	// GetRecords returns all DNS records of a domain.
+	GetRecords(ctx context.Context, domainId int) ([]DnsRecord, error)
+	// GetRecordsByHostname returns the DNS records of recordType at
+	// hostname. An empty recordType returns records of all types.
+	GetRecordsByHostname(ctx context.Context, hostname string, recordType string) ([]DnsRecord, error)
+	// AddTxtRecord creates a TXT record and returns the record Dynu stored.
+	AddTxtRecord(ctx context.Context, domainId int, record TxtRecordRequest) (DnsRecord, error)
+	// DeleteRecord removes a single DNS record from a domain.
+	DeleteRecord(ctx context.Context, domainId int, recordId int) error
+}
+
+// Client talks to the Dynu API on behalf of a single API key.
+type Client struct {
+	apiKey      string
+	apiUrl      string
+	retryPolicy RetryPolicy
+	limiter     *rate.Limiter
+	httpClient  *http.Client
+}
+
+var _ Interface = &Client{}
+
+// NewClient returns a Client authenticating with apiKey. Without options it
+// talks to the public Dynu API.
+func NewClient(apiKey string, opts ...Option) *Client {
+	c := &Client{
+		apiKey:      apiKey,
+		apiUrl:      DefaultApiUrl,
+		retryPolicy: DefaultRetryPolicy(),
+		httpClient:  defaultHTTPClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *Client) GetRoot(ctx context.Context, hostname string) (DNSRootResponse, error) {
+	dnsRootResponse := DNSRootResponse{}
+	response, err := c.callDnsApi(ctx, "/dns/getroot/"+hostname, "GET", nil)
+	if err != nil {
+		return dnsRootResponse, err
+	}
+	if err := json.Unmarshal(response, &dnsRootResponse); err != nil {
+		return dnsRootResponse, fmt.Errorf("unable to unmarshal response %v", err)
+	}
+	return dnsRootResponse, nil
+}
+
+// GetDomains gets a list of the Domains associated with the API to allow for
+// an enumerated check (DYNU API does not have any subdomain filtering)
+func (c *Client) GetDomains(ctx context.Context) ([]Domain, error) {
+	response, err := c.callDnsApi(ctx, "/dns", "GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	domainRecordsResponse := DomainRecordResponse{}
+	if err := json.Unmarshal(response, &domainRecordsResponse); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal response %v", err)
+	}
+	return domainRecordsResponse.Domains, nil
+}
+
+func (c *Client) GetDomain(ctx context.Context, domainId int) (Domain, error) {
+	domain := Domain{}
+	response, err := c.callDnsApi(ctx, fmt.Sprintf("/dns/%d", domainId), "GET", nil)
+	if err != nil {
+		return domain, err
+	}
+	if err := json.Unmarshal(response, &domain); err != nil {
+		return domain, fmt.Errorf("unable to unmarshal response %v", err)
+	}
+	return domain, nil
+}
+
+func (c *Client) GetRecords(ctx context.Context, domainId int) ([]DnsRecord, error) {
+	response, err := c.callDnsApi(ctx, fmt.Sprintf("/dns/%d/record", domainId), "GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	dnsRecordsResponse := DnsRecordResponse{}
+	if err := json.Unmarshal(response, &dnsRecordsResponse); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal response %v", err)
+	}
+	return dnsRecordsResponse.DnsRecords, nil
+}
+
+func (c *Client) GetRecordsByHostname(ctx context.Context, hostname string, recordType string) ([]DnsRecord, error) {
+	path := "/dns/record/" + url.PathEscape(hostname)
+	if recordType != "" {
+		path += "?recordType=" + url.QueryEscape(recordType)
+	}
+	response, err := c.callDnsApi(ctx, path, "GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	dnsRecordsResponse := DnsRecordResponse{}
+	if err := json.Unmarshal(response, &dnsRecordsResponse); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal response %v", err)
+	}
+	return dnsRecordsResponse.DnsRecords, nil
+}
+
+func (c *Client) AddTxtRecord(ctx context.Context, domainId int, record TxtRecordRequest) (DnsRecord, error) {
+	dnsRecord := DnsRecord{}
+	record.RecordType = "TXT"
+	jsonBody, err := json.Marshal(record)
+	if err != nil {
+		return dnsRecord, err
+	}
+	response, err := c.callDnsApi(ctx, fmt.Sprintf("/dns/%d/record", domainId), "POST", jsonBody)
+	if err != nil {
+		return dnsRecord, err
+	}
+	klog.Infof("Added TXT record result: %s", string(response))
+	if err := json.Unmarshal(response, &dnsRecord); err != nil {
+		return dnsRecord, fmt.Errorf("unable to unmarshal response %v", err)
+	}
+	return dnsRecord, nil
+}
+
+func (c *Client) DeleteRecord(ctx context.Context, domainId int, recordId int) error {
+	response, err := c.callDnsApi(ctx, fmt.Sprintf("/dns/%d/record/%d", domainId, recordId), "DELETE", nil)
+	if err != nil {
+		return err
+	}
+	klog.Infof("Deleted TXT record result: %s", string(response))
+	return nil
+}
+
+func (c *Client) callDnsApi(ctx context.Context, path string, method string, body []byte) ([]byte, error) {
+	url := c.apiUrl + path
+	policy := c.retryPolicy
+	if policy.MaxElapsed > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, policy.MaxElapsed)
+		defer cancel()
+	}
+
+	for attempt := 1; ; attempt++ {
+		if c.limiter != nil {
+			if err := c.limiter.Wait(ctx); err != nil {
+				return nil, fmt.Errorf("waiting for Dynu API rate limiter: %w", err)
+			}
+		}
+		respBody, header, err := c.doRequest(ctx, url, method, body)
+		if err == nil {
+			return respBody, nil
+		}
+		if attempt >= policy.MaxAttempts || !shouldRetry(method, retryStatus(err)) || ctx.Err() != nil {
+			return nil, err
+		}
+
+		wait := policy.backoff(attempt)
+		if d, ok := retryAfter(header, time.Now()); ok {
+			wait = d
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			klog.Errorf("Not retrying %s %s: waiting %v would exceed the deadline", method, url, wait)
+			return nil, err
+		}
+		klog.Infof("Retrying %s %s in %v (attempt %d/%d): %v", method, url, wait, attempt+1, policy.MaxAttempts, err)
+		if sleepErr := sleepContext(ctx, wait); sleepErr != nil {
+			return nil, err
+		}
+	}
+}
+
+// doRequest performs a single attempt of an API call. Failed responses are
+// returned as *APIError.
+func (c *Client) doRequest(ctx context.Context, url string, method string, body []byte) ([]byte, http.Header, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to execute request %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("API-Key", c.apiKey)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		klog.Errorf("Failed to Do request: %v", err)
+		return nil, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		klog.Error(err)
+		return nil, nil, err
+	}
+	// Dynu reports some failures with HTTP 200 and the actual status in the body.
+	if resp.StatusCode == http.StatusOK {
+		if status := bodyStatusCode(respBody); status == 0 || status == http.StatusOK {
+			return respBody, resp.Header, nil
+		}
+	}
+
+	apiErr := newAPIError(method, url, resp.StatusCode, respBody)
+	klog.Error(apiErr)
+	return nil, resp.Header, apiErr
+}
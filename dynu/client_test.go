@@ -0,0 +1,109 @@
+package dynu
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return NewClient("test-key", WithApiUrl(server.URL))
+}
+
+func TestClient_GetRoot(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "GET", r.Method)
+		assert.Equal(t, "/dns/getroot/_acme-challenge.example.com", r.URL.Path)
+		assert.Equal(t, "test-key", r.Header.Get("API-Key"))
+		w.Write([]byte(`{"statusCode":200,"id":42,"domainName":"example.com","hostname":"_acme-challenge.example.com","node":"_acme-challenge"}`))
+	})
+
+	root, err := client.GetRoot(context.Background(), "_acme-challenge.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, DNSRootResponse{Id: 42, DomainName: "example.com", Hostname: "_acme-challenge.example.com", Node: "_acme-challenge"}, root)
+}
+
+func TestClient_GetDomains(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/dns", r.URL.Path)
+		w.Write([]byte(`{"statusCode":200,"domains":[{"id":1,"name":"example.com"},{"id":2,"name":"sub.example.com"}]}`))
+	})
+
+	domains, err := client.GetDomains(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, domains, 2)
+	assert.Equal(t, "sub.example.com", domains[1].Name)
+}
+
+func TestClient_AddTxtRecord(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/dns/42/record", r.URL.Path)
+		body := TxtRecordRequest{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, "TXT", body.RecordType)
+		assert.Equal(t, "_acme-challenge", body.NodeName)
+		w.Write([]byte(`{"statusCode":200,"id":7,"domainId":42,"nodeName":"_acme-challenge","recordType":"TXT","textData":"key"}`))
+	})
+
+	record, err := client.AddTxtRecord(context.Background(), 42, TxtRecordRequest{NodeName: "_acme-challenge", TextData: "key"})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, record.Id)
+	assert.Equal(t, "key", record.TextData)
+}
+
+func TestClient_DeleteRecord_Error(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "DELETE", r.Method)
+		assert.Equal(t, "/dns/42/record/7", r.URL.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	err := client.DeleteRecord(context.Background(), 42, 7)
+	assert.Error(t, err)
+}
+
+func TestValidateApiUrl(t *testing.T) {
+	for _, apiUrl := range []string{"https://api.dynu.com/v2", "http://127.0.0.1:8080", "https://proxy.example.com/dynu/v2/"} {
+		assert.NoError(t, ValidateApiUrl(apiUrl), apiUrl)
+	}
+	for _, apiUrl := range []string{"", "api.dynu.com/v2", "ftp://api.dynu.com", "https://", "https://api.dynu.com/v2?x=1", "://bad"} {
+		assert.Error(t, ValidateApiUrl(apiUrl), apiUrl)
+	}
+}
+
+func TestWithApiUrl(t *testing.T) {
+	assert.Equal(t, DefaultApiUrl, NewClient("k", WithApiUrl("")).apiUrl)
+	assert.Equal(t, "https://proxy.example.com/v2", NewClient("k", WithApiUrl("https://proxy.example.com/v2/")).apiUrl)
+}
+
+func TestClient_GetRecordsByHostname(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "GET", r.Method)
+		assert.Equal(t, "/dns/record/_acme-challenge.example.com", r.URL.Path)
+		assert.Equal(t, "TXT", r.URL.Query().Get("recordType"))
+		w.Write([]byte(`{"statusCode":200,"dnsRecords":[{"id":7,"domainId":42,"nodeName":"_acme-challenge","recordType":"TXT","textData":"key"}]}`))
+	})
+
+	records, err := client.GetRecordsByHostname(context.Background(), "_acme-challenge.example.com", "TXT")
+	assert.NoError(t, err)
+	assert.Equal(t, []DnsRecord{{Id: 7, DomainId: 42, NodeName: "_acme-challenge", RecordType: "TXT", TextData: "key"}}, records)
+}
+
+func TestClient_GetDomain(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/dns/42", r.URL.Path)
+		w.Write([]byte(`{"statusCode":200,"id":42,"name":"example.com","ttl":120}`))
+	})
+
+	domain, err := client.GetDomain(context.Background(), 42)
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com", domain.Name)
+	assert.Equal(t, 120, domain.Ttl)
+}
@@ -0,0 +1,126 @@
+package dynu
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrAuth reports that Dynu rejected the API key.
+	ErrAuth = errors.New("dynu: authentication failed")
+	// ErrNotFound reports that the addressed domain or record does not exist.
+	ErrNotFound = errors.New("dynu: not found")
+	// ErrRateLimited reports that Dynu throttled the request.
+	ErrRateLimited = errors.New("dynu: rate limited")
+	// ErrValidation reports that Dynu rejected the request content.
+	ErrValidation = errors.New("dynu: validation error")
+)
+
+// APIError is a failed Dynu API call. Dynu describes failures with a JSON
+// body of the form {"statusCode":401,"type":"Authentication Exception",
+// "message":"..."}.
+type APIError struct {
+	// HTTPStatus is the status code of the HTTP response.
+	HTTPStatus int
+	// StatusCode is the status code reported in the body. It falls back to
+	// HTTPStatus when the body does not contain an error status.
+	StatusCode int `json:"statusCode"`
+	// Type is Dynu's exception type, e.g. "Authentication Exception".
+	Type string `json:"type"`
+	// Message is Dynu's human readable reason.
+	Message string `json:"message"`
+
+	Method string
+	URL    string
+}
+
+func (e *APIError) Error() string {
+	reason := e.Message
+	if reason == "" {
+		reason = http.StatusText(e.HTTPStatus)
+	}
+	if e.Type != "" {
+		reason = e.Type + ": " + reason
+	}
+	return fmt.Sprintf("Dynu API %s %s failed with status %d: %s", e.Method, e.URL, e.StatusCode, reason)
+}
+
+// Is makes errors.Is match the sentinel errors of this package.
+func (e *APIError) Is(target error) bool {
+	t := strings.ToLower(e.Type)
+	switch target {
+	case ErrAuth:
+		return e.hasStatus(http.StatusUnauthorized, http.StatusForbidden) || strings.Contains(t, "authentication")
+	case ErrNotFound:
+		return e.hasStatus(http.StatusNotFound) || strings.Contains(t, "not found") || strings.Contains(t, "notfound")
+	case ErrRateLimited:
+		return e.hasStatus(http.StatusTooManyRequests) || strings.Contains(t, "rate limit")
+	case ErrValidation:
+		return e.hasStatus(http.StatusBadRequest, http.StatusUnprocessableEntity) || strings.Contains(t, "validation") || strings.Contains(t, "argument")
+	}
+	return false
+}
+
+func (e *APIError) hasStatus(codes ...int) bool {
+	for _, code := range codes {
+		if e.StatusCode == code || e.HTTPStatus == code {
+			return true
+		}
+	}
+	return false
+}
+
+// maxErrorBodyLength caps how much of a non-JSON error body ends up in the
+// error message.
+const maxErrorBodyLength = 200
+
+// newAPIError builds the APIError for a response that is not a success.
+// body is parsed on a best effort basis.
+func newAPIError(method string, url string, httpStatus int, body []byte) *APIError {
+	apiErr := &APIError{}
+	if err := json.Unmarshal(body, apiErr); err != nil {
+		message := strings.TrimSpace(string(body))
+		if len(message) > maxErrorBodyLength {
+			message = message[:maxErrorBodyLength] + "..."
+		}
+		apiErr = &APIError{Message: message}
+	}
+	apiErr.HTTPStatus = httpStatus
+	if apiErr.StatusCode < 400 {
+		apiErr.StatusCode = httpStatus
+	}
+	apiErr.Method = method
+	apiErr.URL = url
+	return apiErr
+}
+
+// bodyStatusCode returns the statusCode field Dynu includes in its response
+// bodies, or 0 if there is none.
+func bodyStatusCode(body []byte) int {
+	status := struct {
+		StatusCode int `json:"statusCode"`
+	}{}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return 0
+	}
+	return status.StatusCode
+}
+
+// retryStatus returns the status code deciding whether err is retried, or 0
+// for errors without a response.
+func retryStatus(err error) int {
+	apiErr := &APIError{}
+	if errors.As(err, &apiErr) {
+		if apiErr.Is(ErrRateLimited) {
+			return http.StatusTooManyRequests
+		}
+		if apiErr.HTTPStatus != http.StatusOK {
+			return apiErr.HTTPStatus
+		}
+		return apiErr.StatusCode
+	}
+	return 0
+}
@@ -0,0 +1,55 @@
+package dynu
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIError_Sentinels(t *testing.T) {
+	for _, test := range []struct {
+		httpStatus int
+		body       string
+		sentinel   error
+	}{
+		{http.StatusUnauthorized, `{"statusCode":401,"type":"Authentication Exception","message":"API-Key Incorrect"}`, ErrAuth},
+		{http.StatusOK, `{"statusCode":501,"type":"Authentication Exception","message":"Invalid API key"}`, ErrAuth},
+		{http.StatusNotFound, `{"statusCode":404,"type":"Not Found Exception","message":"Record not found"}`, ErrNotFound},
+		{http.StatusTooManyRequests, ``, ErrRateLimited},
+		{http.StatusBadRequest, `{"statusCode":400,"type":"Validation Exception","message":"TTL is invalid"}`, ErrValidation},
+		{http.StatusInternalServerError, `{"statusCode":502,"type":"Argument Exception","message":"Invalid node name"}`, ErrValidation},
+	} {
+		err := newAPIError("POST", "https://api.dynu.com/v2/dns/1/record", test.httpStatus, []byte(test.body))
+		assert.True(t, errors.Is(err, test.sentinel), "%s should be %v", err, test.sentinel)
+		for _, other := range []error{ErrAuth, ErrNotFound, ErrRateLimited, ErrValidation} {
+			if other != test.sentinel {
+				assert.False(t, errors.Is(err, other), "%s should not be %v", err, other)
+			}
+		}
+	}
+}
+
+func TestAPIError_Message(t *testing.T) {
+	err := newAPIError("POST", "https://api.dynu.com/v2/dns/1/record", http.StatusBadRequest, []byte(`{"statusCode":400,"type":"Validation Exception","message":"TTL is invalid"}`))
+	assert.Equal(t, "Dynu API POST https://api.dynu.com/v2/dns/1/record failed with status 400: Validation Exception: TTL is invalid", err.Error())
+
+	err = newAPIError("GET", "https://api.dynu.com/v2/dns", http.StatusBadGateway, []byte(`<html>bad gateway</html>`))
+	assert.Equal(t, 502, err.StatusCode)
+	assert.Equal(t, "Dynu API GET https://api.dynu.com/v2/dns failed with status 502: <html>bad gateway</html>", err.Error())
+}
+
+func TestClient_ReturnsAPIError(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"statusCode":501,"type":"Authentication Exception","message":"Invalid API key"}`))
+	})
+
+	_, err := client.GetDomains(context.Background())
+	apiErr := &APIError{}
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "Invalid API key", apiErr.Message)
+	assert.ErrorIs(t, err, ErrAuth)
+}
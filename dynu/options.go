@@ -0,0 +1,39 @@
+package dynu
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithApiUrl points the Client at a different Dynu API base URL, e.g. a local
+// stand-in or a recording proxy. An empty value keeps the default.
+func WithApiUrl(apiUrl string) Option {
+	return func(c *Client) {
+		if apiUrl != "" {
+			c.apiUrl = strings.TrimSuffix(apiUrl, "/")
+		}
+	}
+}
+
+// ValidateApiUrl checks that apiUrl is an absolute http(s) URL usable as a
+// Dynu API base URL.
+func ValidateApiUrl(apiUrl string) error {
+	u, err := url.Parse(apiUrl)
+	if err != nil {
+		return fmt.Errorf("invalid Dynu API URL %q: %v", apiUrl, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid Dynu API URL %q: scheme must be http or https", apiUrl)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid Dynu API URL %q: missing host", apiUrl)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("invalid Dynu API URL %q: must not contain a query or fragment", apiUrl)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package dynu
+
+import (
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimiters hands out one token bucket per Dynu API key, so that all
+// clients using the same account share a single request budget.
+type RateLimiters struct {
+	limit rate.Limit
+	burst int
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// NewRateLimiters returns RateLimiters allowing requestsPerSecond requests
+// with bursts of up to burst requests per API key. A requestsPerSecond of 0
+// or less disables rate limiting.
+func NewRateLimiters(requestsPerSecond float64, burst int) *RateLimiters {
+	limit := rate.Limit(requestsPerSecond)
+	if requestsPerSecond <= 0 {
+		limit = rate.Inf
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiters{
+		limit:    limit,
+		burst:    burst,
+		limiters: map[string]*rate.Limiter{},
+	}
+}
+
+// For returns the limiter of apiKey, creating it on first use.
+func (r *RateLimiters) For(apiKey string) *rate.Limiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l, ok := r.limiters[apiKey]
+	if !ok {
+		l = rate.NewLimiter(r.limit, r.burst)
+		r.limiters[apiKey] = l
+	}
+	return l
+}
+
+// Forget drops the limiter of apiKey, e.g. after the key has been rotated.
+func (r *RateLimiters) Forget(apiKey string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.limiters, apiKey)
+}
+
+// WithRateLimiter makes the Client wait for limiter before every request,
+// including retries.
+func WithRateLimiter(limiter *rate.Limiter) Option {
+	return func(c *Client) {
+		c.limiter = limiter
+	}
+}
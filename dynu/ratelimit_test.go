@@ -0,0 +1,51 @@
+package dynu
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiters_SharedPerApiKey(t *testing.T) {
+	limiters := NewRateLimiters(1, 2)
+	assert.Same(t, limiters.For("a"), limiters.For("a"))
+	assert.NotSame(t, limiters.For("a"), limiters.For("b"))
+
+	l := limiters.For("a")
+	limiters.Forget("a")
+	assert.NotSame(t, l, limiters.For("a"))
+}
+
+func TestRateLimiters_Disabled(t *testing.T) {
+	l := NewRateLimiters(0, 0).For("a")
+	for i := 0; i < 100; i++ {
+		assert.True(t, l.Allow())
+	}
+}
+
+func TestClient_WaitsForRateLimiter(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"statusCode":200,"domains":[]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	limiter := NewRateLimiters(0.001, 1).For("test-key")
+	client := NewClient("test-key", WithApiUrl(server.URL), WithRateLimiter(limiter))
+
+	_, err := client.GetDomains(context.Background())
+	assert.NoError(t, err)
+
+	// The bucket is now empty; the next call must block until the context
+	// is done instead of being sent.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = client.GetDomains(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, 1, requests)
+}
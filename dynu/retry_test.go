@@ -0,0 +1,115 @@
+package dynu
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fastRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+		MaxElapsed:     5 * time.Second,
+	}
+}
+
+func newRetryTestClient(t *testing.T, policy RetryPolicy, statuses []int, header http.Header) (*Client, *int) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[len(statuses)-1]
+		if attempts < len(statuses) {
+			status = statuses[attempts]
+		}
+		attempts++
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`{"statusCode":200,"domains":[]}`))
+	}))
+	t.Cleanup(server.Close)
+	return NewClient("test-key", WithApiUrl(server.URL), WithRetryPolicy(policy)), &attempts
+}
+
+func TestCallDnsApi_RetriesTransientErrors(t *testing.T) {
+	client, attempts := newRetryTestClient(t, fastRetryPolicy(), []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}, nil)
+
+	_, err := client.GetDomains(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, *attempts)
+}
+
+func TestCallDnsApi_GivesUpAfterMaxAttempts(t *testing.T) {
+	client, attempts := newRetryTestClient(t, fastRetryPolicy(), []int{http.StatusBadGateway}, nil)
+
+	_, err := client.GetDomains(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 3, *attempts)
+}
+
+func TestCallDnsApi_DoesNotRetryPermanentErrors(t *testing.T) {
+	client, attempts := newRetryTestClient(t, fastRetryPolicy(), []int{http.StatusUnauthorized}, nil)
+
+	_, err := client.GetDomains(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 1, *attempts)
+}
+
+func TestCallDnsApi_PostOnlyRetriedWhenRateLimited(t *testing.T) {
+	client, attempts := newRetryTestClient(t, fastRetryPolicy(), []int{http.StatusBadGateway}, nil)
+	_, err := client.AddTxtRecord(context.Background(), 1, TxtRecordRequest{NodeName: "_acme-challenge", TextData: "key"})
+	assert.Error(t, err)
+	assert.Equal(t, 1, *attempts)
+
+	client, attempts = newRetryTestClient(t, fastRetryPolicy(), []int{http.StatusTooManyRequests, http.StatusOK}, nil)
+	_, err = client.AddTxtRecord(context.Background(), 1, TxtRecordRequest{NodeName: "_acme-challenge", TextData: "key"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, *attempts)
+}
+
+func TestCallDnsApi_RetryAfterBeyondDeadline(t *testing.T) {
+	policy := fastRetryPolicy()
+	policy.MaxElapsed = 500 * time.Millisecond
+	client, attempts := newRetryTestClient(t, policy, []int{http.StatusTooManyRequests}, http.Header{"Retry-After": []string{"60"}})
+
+	start := time.Now()
+	_, err := client.GetDomains(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 1, *attempts)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"", 0, false},
+		{"5", 5 * time.Second, true},
+		{"-1", 0, false},
+		{"Fri, 15 Mar 2024 12:00:30 GMT", 30 * time.Second, true},
+		{"Fri, 15 Mar 2024 11:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	} {
+		d, ok := retryAfter(http.Header{"Retry-After": []string{test.value}}, now)
+		assert.Equal(t, test.ok, ok, test.value)
+		assert.Equal(t, test.expected, d, test.value)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 8 * time.Second}
+	for retry, max := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 8 * time.Second, 10: 8 * time.Second} {
+		d := policy.backoff(retry)
+		assert.GreaterOrEqual(t, d, max/2)
+		assert.LessOrEqual(t, d, max)
+	}
+}
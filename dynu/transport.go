@@ -0,0 +1,76 @@
+package dynu
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// HTTPClientOptions configures the connection pool and timeouts of the HTTP
+// client used to talk to Dynu.
+type HTTPClientOptions struct {
+	// DialTimeout limits establishing the TCP connection.
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout limits the TLS handshake.
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout limits the wait for the response headers once
+	// the request has been written.
+	ResponseHeaderTimeout time.Duration
+	// Timeout limits a single request from dialing to reading the body.
+	// Retries get a fresh Timeout each.
+	Timeout time.Duration
+	// MaxIdleConnsPerHost is the number of keep-alive connections kept open
+	// to the Dynu API.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout closes keep-alive connections unused for this long.
+	IdleConnTimeout time.Duration
+}
+
+// DefaultHTTPClientOptions returns the HTTP client settings used when none are
+// configured.
+func DefaultHTTPClientOptions() HTTPClientOptions {
+	return HTTPClientOptions{
+		DialTimeout:           10 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		Timeout:               60 * time.Second,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+	}
+}
+
+// NewHTTPClient returns an HTTP client with keep-alives enabled that is meant
+// to be created once and shared by all Clients.
+func NewHTTPClient(o HTTPClientOptions) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   o.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	t := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   o.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: o.ResponseHeaderTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		MaxIdleConns:          o.MaxIdleConnsPerHost,
+		MaxIdleConnsPerHost:   o.MaxIdleConnsPerHost,
+		IdleConnTimeout:       o.IdleConnTimeout,
+	}
+	return &http.Client{
+		Transport: t,
+		Timeout:   o.Timeout,
+	}
+}
+
+// defaultHTTPClient is shared by Clients created without WithHTTPClient.
+var defaultHTTPClient = NewHTTPClient(DefaultHTTPClientOptions())
+
+// WithHTTPClient makes the Client send its requests through httpClient.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		if httpClient != nil {
+			c.httpClient = httpClient
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package dynu
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHTTPClient_ReusesConnections(t *testing.T) {
+	var connections int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"statusCode":200,"domains":[]}`))
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+
+	client := NewClient("test-key", WithApiUrl(server.URL), WithHTTPClient(NewHTTPClient(DefaultHTTPClientOptions())))
+	for i := 0; i < 5; i++ {
+		_, err := client.GetDomains(context.Background())
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&connections))
+}
+
+func TestNewHTTPClient_RequestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	options := DefaultHTTPClientOptions()
+	options.Timeout = 50 * time.Millisecond
+	client := NewClient("test-key", WithApiUrl(server.URL), WithHTTPClient(NewHTTPClient(options)), WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+
+	start := time.Now()
+	_, err := client.GetDomains(context.Background())
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
@@ -0,0 +1,56 @@
+package dynu
+
+type DnsRecordResponse struct {
+	DnsRecords []DnsRecord `json:"dnsRecords"`
+}
+
+type DnsRecord struct {
+	Id         int    `json:"id"`
+	DomainId   int    `json:"domainId"`
+	NodeName   string `json:"nodeName"`
+	RecordType string `json:"recordType"`
+	Ttl        int    `json:"ttl"`
+	Content    string `json:"content"`
+	UpdatedOn  string `json:"updatedOn"`
+	TextData   string `json:"textData"`
+	Group      string `json:"group"`
+}
+
+type DNSRootResponse struct {
+	Id         int    `json:"id"`
+	DomainName string `json:"domainName"`
+	Hostname   string `json:"hostname"`
+	Node       string `json:"node"`
+}
+
+type DomainRecordResponse struct {
+	Domains []Domain `json:"domains"`
+}
+
+type Domain struct {
+	Id                int    `json:"id"`
+	Name              string `json:"name"`
+	UnicodeName       string `json:"unicodeName"`
+	Token             string `json:"token"`
+	State             string `json:"state"`
+	Group             string `json:"group"`
+	Ipv4Address       string `json:"ipv4Address"`
+	Ipv6Address       string `json:"ipv6Address"`
+	Ttl               int    `json:"ttl"`
+	Ipv4              bool   `json:"ipv4"`
+	Ipv6              bool   `json:"ipv6"`
+	Ipv4WildcardAlias bool   `json:"ipv4WildcardAlias"`
+	Ipv6WildcardAlias bool   `json:"ipv6WildcardAlias"`
+	CreatedOn         string `json:"createdOn"`
+	UpdatedOn         string `json:"updatedOn"`
+}
+
+// TxtRecordRequest is the body sent to Dynu when creating a TXT record.
+type TxtRecordRequest struct {
+	NodeName   string `json:"nodeName"`
+	RecordType string `json:"recordType"`
+	Ttl        string `json:"ttl"`
+	Group      string `json:"group"`
+	State      string `json:"state"`
+	TextData   string `json:"textData"`
+}
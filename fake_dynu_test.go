@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Dopingus/cert-manager-webhook-dynu/dynu"
+)
+
+// fakeDynu is an in-memory dynu.Interface. Domains are matched by name the
+// same way the Dynu getroot endpoint does.
+type fakeDynu struct {
+	sync.Mutex
+	domains []dynu.Domain
+	records map[int][]dynu.DnsRecord
+	nextId  int
+	calls   []string
+
+	// addErr and deleteErr make the n-th (1-based) call of AddTxtRecord or
+	// DeleteRecord fail.
+	addErr    map[int]error
+	deleteErr map[int]error
+	adds      int
+	deletes   int
+	// hostnameLookupErr makes every GetRecordsByHostname call fail.
+	hostnameLookupErr error
+}
+
+var _ dynu.Interface = &fakeDynu{}
+
+func newFakeDynu(domains ...dynu.Domain) *fakeDynu {
+	return &fakeDynu{
+		domains:   domains,
+		records:   map[int][]dynu.DnsRecord{},
+		nextId:    100,
+		addErr:    map[int]error{},
+		deleteErr: map[int]error{},
+	}
+}
+
+func (f *fakeDynu) GetRoot(ctx context.Context, hostname string) (dynu.DNSRootResponse, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.calls = append(f.calls, "GetRoot "+hostname)
+	best, ok := f.domainOf(hostname)
+	if !ok {
+		return dynu.DNSRootResponse{}, &dynu.APIError{HTTPStatus: http.StatusNotFound, StatusCode: http.StatusNotFound, Message: "domain not found"}
+	}
+	return dynu.DNSRootResponse{
+		Id:         best.Id,
+		DomainName: best.Name,
+		Hostname:   hostname,
+		Node:       strings.TrimSuffix(strings.TrimSuffix(hostname, best.Name), "."),
+	}, nil
+}
+
+// domainOf returns the most specific domain serving hostname.
+func (f *fakeDynu) domainOf(hostname string) (dynu.Domain, bool) {
+	best := dynu.Domain{}
+	for _, d := range f.domains {
+		if (hostname == d.Name || strings.HasSuffix(hostname, "."+d.Name)) && len(d.Name) > len(best.Name) {
+			best = d
+		}
+	}
+	return best, best.Name != ""
+}
+
+func (f *fakeDynu) GetDomains(ctx context.Context) ([]dynu.Domain, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.calls = append(f.calls, "GetDomains")
+	return append([]dynu.Domain{}, f.domains...), nil
+}
+
+func (f *fakeDynu) GetDomain(ctx context.Context, domainId int) (dynu.Domain, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.calls = append(f.calls, fmt.Sprintf("GetDomain %d", domainId))
+	for _, d := range f.domains {
+		if d.Id == domainId {
+			return d, nil
+		}
+	}
+	return dynu.Domain{}, &dynu.APIError{HTTPStatus: http.StatusNotFound, StatusCode: http.StatusNotFound, Message: "domain not found"}
+}
+
+func (f *fakeDynu) GetRecords(ctx context.Context, domainId int) ([]dynu.DnsRecord, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.calls = append(f.calls, fmt.Sprintf("GetRecords %d", domainId))
+	return append([]dynu.DnsRecord{}, f.records[domainId]...), nil
+}
+
+func (f *fakeDynu) GetRecordsByHostname(ctx context.Context, hostname string, recordType string) ([]dynu.DnsRecord, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.calls = append(f.calls, "GetRecordsByHostname "+hostname)
+	if f.hostnameLookupErr != nil {
+		return nil, f.hostnameLookupErr
+	}
+	domain, ok := f.domainOf(hostname)
+	if !ok {
+		return nil, &dynu.APIError{HTTPStatus: http.StatusNotFound, StatusCode: http.StatusNotFound, Message: "domain not found"}
+	}
+	node := strings.TrimSuffix(strings.TrimSuffix(hostname, domain.Name), ".")
+	records := []dynu.DnsRecord{}
+	for _, r := range f.records[domain.Id] {
+		if r.NodeName == node && (recordType == "" || r.RecordType == recordType) {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+func (f *fakeDynu) AddTxtRecord(ctx context.Context, domainId int, record dynu.TxtRecordRequest) (dynu.DnsRecord, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.adds++
+	f.calls = append(f.calls, fmt.Sprintf("AddTxtRecord %d %s", domainId, record.NodeName))
+	if err := f.addErr[f.adds]; err != nil {
+		return dynu.DnsRecord{}, err
+	}
+	f.nextId++
+	ttl, _ := strconv.Atoi(record.Ttl)
+	r := dynu.DnsRecord{
+		Id:         f.nextId,
+		Ttl:        ttl,
+		DomainId:   domainId,
+		NodeName:   record.NodeName,
+		RecordType: "TXT",
+		TextData:   record.TextData,
+		Group:      record.Group,
+	}
+	f.records[domainId] = append(f.records[domainId], r)
+	return r, nil
+}
+
+func (f *fakeDynu) DeleteRecord(ctx context.Context, domainId int, recordId int) error {
+	f.Lock()
+	defer f.Unlock()
+	f.deletes++
+	f.calls = append(f.calls, fmt.Sprintf("DeleteRecord %d %d", domainId, recordId))
+	if err := f.deleteErr[f.deletes]; err != nil {
+		return err
+	}
+	for i, r := range f.records[domainId] {
+		if r.Id == recordId {
+			f.records[domainId] = append(f.records[domainId][:i], f.records[domainId][i+1:]...)
+			return nil
+		}
+	}
+	return &dynu.APIError{HTTPStatus: http.StatusNotFound, StatusCode: http.StatusNotFound, Message: "record not found"}
+}
+
+// txtRecords returns the node names of all TXT records of domainId holding key.
+func (f *fakeDynu) txtRecords(domainId int, key string) []string {
+	f.Lock()
+	defer f.Unlock()
+	names := []string{}
+	for _, r := range f.records[domainId] {
+		if r.RecordType == "TXT" && r.TextData == key {
+			names = append(names, r.NodeName)
+		}
+	}
+	return names
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog"
+
+	cmclient "github.com/cert-manager/cert-manager/pkg/client/clientset/versioned"
+
+	"github.com/Dopingus/cert-manager-webhook-dynu/dynu"
+)
+
+var (
+	gcRunsTotal = metrics.NewCounterVec(&metrics.CounterOpts{
+		Namespace:      "dynu_webhook",
+		Subsystem:      "gc",
+		Name:           "runs_total",
+		Help:           "Number of garbage collection runs by result.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"result"})
+	gcRecordsTotal = metrics.NewCounterVec(&metrics.CounterOpts{
+		Namespace:      "dynu_webhook",
+		Subsystem:      "gc",
+		Name:           "orphaned_records_total",
+		Help:           "Number of orphaned ACME TXT records found by the garbage collector, by action taken (deleted, dry_run, failed).",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"action"})
+	gcStoreEntriesTotal = metrics.NewCounterVec(&metrics.CounterOpts{
+		Namespace:      "dynu_webhook",
+		Subsystem:      "gc",
+		Name:           "orphaned_store_entries_total",
+		Help:           "Number of record store entries of no longer existing challenges found by the garbage collector, by action taken (deleted, dry_run, failed).",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"action"})
+	gcLastRunTimestamp = metrics.NewGauge(&metrics.GaugeOpts{
+		Namespace:      "dynu_webhook",
+		Subsystem:      "gc",
+		Name:           "last_run_timestamp_seconds",
+		Help:           "Unix time of the last completed garbage collection run.",
+		StabilityLevel: metrics.ALPHA,
+	})
+)
+
+func init() {
+	legacyregistry.MustRegister(gcRunsTotal, gcRecordsTotal, gcStoreEntriesTotal, gcLastRunTimestamp)
+}
+
+// dynuTimeLayout is the format of the createdOn and updatedOn fields of the
+// Dynu API. The times carry no zone and are in UTC.
+const dynuTimeLayout = "2006-01-02T15:04:05.999999999"
+
+// garbageCollector periodically deletes ACME TXT records the webhook created
+// but never cleaned up, e.g. because it crashed mid-challenge or a Challenge
+// was deleted without CleanUp being called, together with their entries in
+// the record store.
+type garbageCollector struct {
+	interval time.Duration
+	// minAge keeps records that were updated more recently than this.
+	minAge time.Duration
+	dryRun bool
+	// groups are the ownership markers of the records to collect; records in
+	// other groups are never touched.
+	groups []string
+
+	// apiKeys returns the Dynu API keys whose domains are scanned.
+	apiKeys func(ctx context.Context) ([]string, error)
+	// newClient builds the Dynu client for an API key.
+	newClient func(apiKey string) dynu.Interface
+	// live returns the Challenges that still exist.
+	live func(ctx context.Context) (liveChallenges, error)
+	// store is the record store to prune. It is nil when the store is
+	// disabled.
+	store recordStore
+
+	now func() time.Time
+}
+
+// Run collects garbage every interval until stopCh is closed.
+func (g *garbageCollector) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting garbage collector: interval=%v, minAge=%v, dryRun=%v, groups=%q", g.interval, g.minAge, g.dryRun, g.groups)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+	wait.Until(func() {
+		if err := g.collect(ctx); err != nil {
+			klog.Errorf("Garbage collection failed: %v", err)
+			gcRunsTotal.WithLabelValues("error").Inc()
+		} else {
+			gcRunsTotal.WithLabelValues("success").Inc()
+		}
+		gcLastRunTimestamp.Set(float64(g.now().Unix()))
+	}, g.interval, stopCh)
+}
+
+// collect runs a single garbage collection over all domains reachable with
+// the configured API keys.
+func (g *garbageCollector) collect(ctx context.Context) error {
+	live, err := g.live(ctx)
+	if err != nil {
+		// Without knowing the live challenges nothing can be deleted safely.
+		return fmt.Errorf("unable to list challenges: %w", err)
+	}
+
+	var errs []error
+	if g.store != nil {
+		if err := g.pruneStore(ctx, live); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	apiKeys, err := g.apiKeys(ctx)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	for _, apiKey := range apiKeys {
+		dynuClient := g.newClient(apiKey)
+		domains, err := dynuClient.GetDomains(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unable to get domains: %w", err))
+			continue
+		}
+		for _, domain := range domains {
+			if err := g.collectDomain(ctx, dynuClient, domain, live.keys); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (g *garbageCollector) collectDomain(ctx context.Context, dynuClient dynu.Interface, domain dynu.Domain, liveKeys map[string]bool) error {
+	records, err := dynuClient.GetRecords(ctx, domain.Id)
+	if err != nil {
+		return fmt.Errorf("unable to get DNS records of %s: %w", domain.Name, err)
+	}
+
+	var errs []error
+	for _, record := range records {
+		if record.RecordType != "TXT" || !g.ownedRecord(record) || liveKeys[record.TextData] {
+			continue
+		}
+		updatedOn, err := time.Parse(dynuTimeLayout, record.UpdatedOn)
+		if err != nil {
+			klog.Infof("Skipping TXT record %d at %q of %s: unable to parse updatedOn %q", record.Id, record.NodeName, domain.Name, record.UpdatedOn)
+			continue
+		}
+		age := g.now().Sub(updatedOn)
+		if age < g.minAge {
+			continue
+		}
+
+		if g.dryRun {
+			klog.Infof("Dry run: would delete orphaned TXT record %d at %q of %s (age %v)", record.Id, record.NodeName, domain.Name, age.Round(time.Second))
+			gcRecordsTotal.WithLabelValues("dry_run").Inc()
+			continue
+		}
+		err = dynuClient.DeleteRecord(ctx, domain.Id, record.Id)
+		if err != nil && !errors.Is(err, dynu.ErrNotFound) {
+			gcRecordsTotal.WithLabelValues("failed").Inc()
+			errs = append(errs, fmt.Errorf("unable to delete TXT record %d of %s: %w", record.Id, domain.Name, err))
+			continue
+		}
+		klog.Infof("Deleted orphaned TXT record %d at %q of %s (age %v)", record.Id, record.NodeName, domain.Name, age.Round(time.Second))
+		gcRecordsTotal.WithLabelValues("deleted").Inc()
+	}
+	return errors.Join(errs...)
+}
+
+// pruneStore removes the record store entries of Challenges that no longer
+// exist. Entries saved less than minAge ago are kept, as their Challenge may
+// have been created after live was listed.
+func (g *garbageCollector) pruneStore(ctx context.Context, live liveChallenges) error {
+	entries, err := g.store.List(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to list the record store: %w", err)
+	}
+	var uids []string
+	for _, records := range entries {
+		if live.uids[records.UID] || (records.SavedAt != nil && g.now().Sub(*records.SavedAt) < g.minAge) {
+			continue
+		}
+		if g.dryRun {
+			klog.Infof("Dry run: would remove the stored records of challenge %s", records.UID)
+			gcStoreEntriesTotal.WithLabelValues("dry_run").Inc()
+			continue
+		}
+		uids = append(uids, records.UID)
+	}
+	if err := g.store.Prune(ctx, uids); err != nil {
+		gcStoreEntriesTotal.WithLabelValues("failed").Add(float64(len(uids)))
+		return fmt.Errorf("unable to prune the record store: %w", err)
+	}
+	if len(uids) > 0 {
+		klog.Infof("Removed the stored records of %d challenges that no longer exist", len(uids))
+		gcStoreEntriesTotal.WithLabelValues("deleted").Add(float64(len(uids)))
+	}
+	return nil
+}
+
+// ownedRecord reports whether record is in one of the groups collected.
+func (g *garbageCollector) ownedRecord(record dynu.DnsRecord) bool {
+	for _, group := range g.groups {
+		if ownedRecord(record, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// liveChallenges are the keys and UIDs of the existing Challenges.
+type liveChallenges struct {
+	keys map[string]bool
+	uids map[string]bool
+}
+
+// listChallenges returns a live function listing the Challenges of all
+// namespaces.
+func listChallenges(client cmclient.Interface) func(ctx context.Context) (liveChallenges, error) {
+	return func(ctx context.Context) (liveChallenges, error) {
+		live := liveChallenges{keys: map[string]bool{}, uids: map[string]bool{}}
+		challenges, err := client.AcmeV1().Challenges(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return live, err
+		}
+		for _, challenge := range challenges.Items {
+			live.keys[challenge.Spec.Key] = true
+			live.uids[string(challenge.UID)] = true
+		}
+		return live, nil
+	}
+}
+
+// secretAPIKeys returns an apiKeys function reading the API key of each
+// referenced Secret.
+func secretAPIKeys(secrets secretGetter, refs []secretKeySelector) func(ctx context.Context) ([]string, error) {
+	return func(ctx context.Context) ([]string, error) {
+		var apiKeys []string
+		for _, ref := range refs {
+			apiKey, err := readApiKey(ctx, secrets, ref)
+			if err != nil {
+				return nil, err
+			}
+			apiKeys = append(apiKeys, apiKey)
+		}
+		return apiKeys, nil
+	}
+}
+
+// parseSecretRefs parses references of the form namespace/name[:key]. The key
+// defaults to api-key.
+func parseSecretRefs(refs []string) ([]secretKeySelector, error) {
+	var parsed []secretKeySelector
+	for _, ref := range refs {
+		namespace, name, ok := strings.Cut(ref, "/")
+		name, key, _ := strings.Cut(name, ":")
+		if !ok || namespace == "" || name == "" {
+			return nil, fmt.Errorf("invalid secret reference %q, expected namespace/name[:key]", ref)
+		}
+		if key == "" {
+			key = defaultApiKeySecretKey
+		}
+		parsed = append(parsed, secretKeySelector{Namespace: namespace, Name: name, Key: key})
+	}
+	return parsed, nil
+}
+
+// newGarbageCollector builds the garbage collector configured by the webhook
+// options.
+func (c *dynuDNSProviderSolver) newGarbageCollector(kubeClientConfig *rest.Config) (*garbageCollector, error) {
+	cmClient, err := cmclient.NewForConfig(kubeClientConfig)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := parseSecretRefs(c.options.GCSecrets)
+	if err != nil {
+		return nil, err
+	}
+	cfg := c.withDefaults(dynuDNSProviderConfig{})
+	groups := c.options.GCGroups
+	if len(groups) == 0 {
+		groups = []string{cfg.Group}
+	}
+	return &garbageCollector{
+		interval: c.options.GCInterval,
+		minAge:   c.options.GCMinAge,
+		dryRun:   c.options.GCDryRun,
+		groups:   groups,
+		apiKeys:  secretAPIKeys(c.secrets, refs),
+		newClient: func(apiKey string) dynu.Interface {
+			return c.dynuClient(apiKey, cfg)
+		},
+		live:  listChallenges(cmClient),
+		store: c.store,
+		now:   time.Now,
+	}, nil
+}
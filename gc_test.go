@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/Dopingus/cert-manager-webhook-dynu/dynu"
+)
+
+func newTestGarbageCollector(fake *fakeDynu, liveKeys ...string) *garbageCollector {
+	live := liveChallenges{keys: map[string]bool{}, uids: map[string]bool{}}
+	for _, key := range liveKeys {
+		live.keys[key] = true
+	}
+	return &garbageCollector{
+		minAge: time.Hour,
+		groups: []string{"cert-manager"},
+		apiKeys: func(ctx context.Context) ([]string, error) {
+			return []string{"key"}, nil
+		},
+		newClient: func(apiKey string) dynu.Interface {
+			return fake
+		},
+		live: func(ctx context.Context) (liveChallenges, error) {
+			return live, nil
+		},
+		now: func() time.Time {
+			return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		},
+	}
+}
+
+func TestGarbageCollector_Collect(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"})
+	fake.records[1] = []dynu.DnsRecord{
+		{Id: 1, DomainId: 1, NodeName: "_acme-challenge", RecordType: "TXT", TextData: "orphan", Group: "cert-manager", UpdatedOn: "2024-05-01T10:00:00.123"},
+		{Id: 2, DomainId: 1, NodeName: "_acme-challenge", RecordType: "TXT", TextData: "live", Group: "cert-manager", UpdatedOn: "2024-05-01T10:00:00"},
+		{Id: 3, DomainId: 1, NodeName: "_acme-challenge", RecordType: "TXT", TextData: "recent", Group: "cert-manager", UpdatedOn: "2024-05-01T11:30:00"},
+		{Id: 4, DomainId: 1, NodeName: "_acme-challenge", RecordType: "TXT", TextData: "foreign", Group: "", UpdatedOn: "2024-05-01T10:00:00"},
+		{Id: 5, DomainId: 1, NodeName: "www", RecordType: "CNAME", Group: "cert-manager", UpdatedOn: "2024-05-01T10:00:00"},
+		{Id: 6, DomainId: 1, NodeName: "_acme-challenge", RecordType: "TXT", TextData: "unparsable", Group: "cert-manager", UpdatedOn: "yesterday"},
+	}
+	gc := newTestGarbageCollector(fake, "live")
+
+	gc.dryRun = true
+	assert.NoError(t, gc.collect(context.Background()))
+	assert.Len(t, fake.records[1], 6)
+
+	gc.dryRun = false
+	assert.NoError(t, gc.collect(context.Background()))
+	var ids []int
+	for _, record := range fake.records[1] {
+		ids = append(ids, record.Id)
+	}
+	assert.Equal(t, []int{2, 3, 4, 5, 6}, ids)
+}
+
+func TestGarbageCollector_CollectGroups(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"})
+	fake.records[1] = []dynu.DnsRecord{
+		{Id: 1, DomainId: 1, NodeName: "_acme-challenge", RecordType: "TXT", TextData: "default", Group: "cert-manager", UpdatedOn: "2024-05-01T10:00:00"},
+		{Id: 2, DomainId: 1, NodeName: "_acme-challenge", RecordType: "TXT", TextData: "issuer", Group: "team-a", UpdatedOn: "2024-05-01T10:00:00"},
+		{Id: 3, DomainId: 1, NodeName: "_acme-challenge", RecordType: "TXT", TextData: "other", Group: "team-b", UpdatedOn: "2024-05-01T10:00:00"},
+	}
+	gc := newTestGarbageCollector(fake)
+	gc.groups = []string{"cert-manager", "team-a"}
+
+	assert.NoError(t, gc.collect(context.Background()))
+	assert.Len(t, fake.records[1], 1)
+	assert.Equal(t, 3, fake.records[1][0].Id)
+}
+
+func TestGarbageCollector_CollectWithoutLiveKeys(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"})
+	fake.records[1] = []dynu.DnsRecord{
+		{Id: 1, DomainId: 1, NodeName: "_acme-challenge", RecordType: "TXT", TextData: "orphan", Group: "cert-manager", UpdatedOn: "2024-05-01T10:00:00"},
+	}
+	gc := newTestGarbageCollector(fake)
+	gc.live = func(ctx context.Context) (liveChallenges, error) {
+		return liveChallenges{}, errors.New("forbidden")
+	}
+
+	assert.Error(t, gc.collect(context.Background()))
+	assert.Len(t, fake.records[1], 1)
+}
+
+func TestGarbageCollector_PruneStore(t *testing.T) {
+	ctx := context.Background()
+	gc := newTestGarbageCollector(newFakeDynu())
+	store := newConfigMapRecordStore(fake.NewSimpleClientset(), "cert-manager", "dynu-webhook-records")
+	gc.store = store
+	live, _ := gc.live(ctx)
+	live.uids["live"] = true
+	recent := gc.now().Add(-time.Minute)
+	old := gc.now().Add(-2 * time.Hour)
+	for _, records := range []challengeRecords{{UID: "live", SavedAt: &old}, {UID: "orphan", SavedAt: &old}, {UID: "legacy"}, {UID: "recent", SavedAt: &recent}} {
+		assert.NoError(t, store.Save(ctx, records))
+	}
+
+	gc.dryRun = true
+	assert.NoError(t, gc.collect(ctx))
+	entries, err := store.List(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 4)
+
+	gc.dryRun = false
+	assert.NoError(t, gc.collect(ctx))
+	entries, err = store.List(ctx)
+	assert.NoError(t, err)
+	var uids []string
+	for _, records := range entries {
+		uids = append(uids, records.UID)
+	}
+	assert.Equal(t, []string{"live", "recent"}, uids)
+}
+
+func TestSecretAPIKeys(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cert-manager", Name: "dynu-secret"},
+		Data:       map[string][]byte{"api-key": []byte("secret-key")},
+	}, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "dns", Name: "dynu-credentials"},
+		Data:       map[string][]byte{"token": []byte("other-key")},
+	})
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	secrets := newSecretCache(client, stopCh, nil)
+
+	refs, err := parseSecretRefs([]string{"cert-manager/dynu-secret", "dns/dynu-credentials:token"})
+	assert.NoError(t, err)
+	apiKeys, err := secretAPIKeys(secrets, refs)(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"secret-key", "other-key"}, apiKeys)
+
+	_, err = secretAPIKeys(secrets, []secretKeySelector{{Namespace: "cert-manager", Name: "missing", Key: "api-key"}})(context.Background())
+	assert.Error(t, err)
+
+	refs, err = parseSecretRefs([]string{"cert-manager/dynu-credentials:token"})
+	assert.NoError(t, err)
+	assert.Equal(t, []secretKeySelector{{Namespace: "cert-manager", Name: "dynu-credentials", Key: "token"}}, refs)
+
+	_, err = parseSecretRefs([]string{"dynu-secret"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+// keyedMutex serialises work per key while letting different keys proceed in
+// parallel. The zero value is ready to use.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedMutexEntry
+}
+
+type keyedMutexEntry struct {
+	sync.Mutex
+	refs int
+}
+
+// Lock blocks until key is free and returns the function releasing it.
+func (k *keyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = map[string]*keyedMutexEntry{}
+	}
+	entry, ok := k.locks[key]
+	if !ok {
+		entry = &keyedMutexEntry{}
+		k.locks[key] = entry
+	}
+	entry.refs++
+	k.mu.Unlock()
+
+	entry.Lock()
+	return func() {
+		entry.Unlock()
+		k.mu.Lock()
+		entry.refs--
+		if entry.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// challengeLockKey identifies a challenge for keyedMutex. cert-manager sets
+// the UID of the Challenge resource; requests without one fall back to the
+// record they are about.
+func challengeLockKey(ch *v1alpha1.ChallengeRequest) string {
+	if ch.UID != "" {
+		return string(ch.UID)
+	}
+	return ch.ResolvedFQDN + "/" + ch.Key
+}
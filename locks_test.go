@@ -0,0 +1,31 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	k := keyedMutex{}
+	counters := map[string]int{}
+	var countersMu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		for _, key := range []string{"a", "b"} {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				unlock := k.Lock(key)
+				defer unlock()
+				countersMu.Lock()
+				counters[key]++
+				countersMu.Unlock()
+			}(key)
+		}
+	}
+	wg.Wait()
+	assert.Equal(t, map[string]int{"a": 50, "b": 50}, counters)
+	assert.Empty(t, k.locks)
+}
@@ -1,84 +1,35 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/klog"
 
+	"github.com/spf13/pflag"
+
 	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
 	"github.com/cert-manager/cert-manager/pkg/acme/webhook/cmd"
 	"github.com/cert-manager/cert-manager/pkg/issuer/acme/dns/util"
-)
 
-const (
-	apiUrl = "https://api.dynu.com/v2"
+	"github.com/Dopingus/cert-manager-webhook-dynu/dynu"
 )
 
-type DnsRecordResponse struct {
-	DnsRecords []DnsRecord `json:"dnsRecords"`
-}
-
-type DnsRecord struct {
-	Id         int    `json:"id"`
-	DomainId   int    `json:"domainId"`
-	NodeName   string `json:"nodeName"`
-	RecordType string `json:"recordType"`
-	Ttl        int    `json:"ttl"`
-	Content    string `json:"content"`
-	UpdatedOn  string `json:"updatedOn"`
-	TextData   string `json:"textData"`
-}
-
-type DNSRootResponse struct {
-	Id         int    `json:"id"`
-	DomainName string `json:"domainName"`
-	Hostname   string `json:"hostname"`
-	Node       string `json:"node"`
-}
-
 type DNSSubResponse struct {
 	Id         int    `json:"id"`
 	DomainName string `json:"domainName"`
 	Node       string `json:"node"`
 }
 
-type DomainRecordResponse struct {
-	Domains []Domain `json:"domains"`
-}
-
-type Domain struct {
-	Id                int    `json:"id"`
-	Name              string `json:"name"`
-	UnicodeName       string `json:"unicodeName"`
-	Token             string `json:"token"`
-	State             string `json:"state"`
-	Group             string `json:"group"`
-	Ipv4Address       string `json:"ipv4Address"`
-	Ipv6Address       string `json:"ipv6Address"`
-	Ttl               int    `json:"ttl"`
-	Ipv4              bool   `json:"ipv4"`
-	Ipv6              bool   `json:"ipv6"`
-	Ipv4WildcardAlias bool   `json:"ipv4WildcardAlias"`
-	Ipv6WildcardAlias bool   `json:"ipv6WildcardAlias"`
-	CreatedOn         string `json:"createdOn"`
-	UpdatedOn         string `json:"updatedOn"`
-}
-
 var GroupName = os.Getenv("GROUP_NAME")
 
 func main() {
@@ -91,8 +42,11 @@ func main() {
 	// You can register multiple DNS provider implementations with a single
 	// webhook, where the Name() method will be used to disambiguate between
 	// the different implementations.
+	solver := newDynuDNSProviderSolver()
+	solver.options.AddFlags(pflag.CommandLine)
+
 	cmd.RunWebhookServer(GroupName,
-		&dynuDNSProviderSolver{},
+		solver,
 	)
 }
 
@@ -101,7 +55,36 @@ func main() {
 // To do so, it must implement the `github.com/jetstack/cert-manager/pkg/acme/webhook.Solver`
 // interface.
 type dynuDNSProviderSolver struct {
-	client *kubernetes.Clientset
+	client  kubernetes.Interface
+	options webhookOptions
+	// secrets serves the Secrets holding the Dynu API keys from a cache.
+	secrets secretGetter
+	// ambient is the default API key for challenges without a secret. It is
+	// nil when none is configured.
+	ambient *ambientCredentials
+	// rateLimiters throttles Dynu API calls per API key across all
+	// concurrent challenges.
+	rateLimiters *dynu.RateLimiters
+	// httpClient is the pooled HTTP client shared by all Dynu API calls.
+	httpClient *http.Client
+	// challengeLocks serialises Present and CleanUp calls for the same
+	// challenge, so that retries cannot race each other into duplicates.
+	challengeLocks keyedMutex
+	// store remembers the records created per challenge. It is nil when no
+	// namespace for it is configured.
+	store recordStore
+	// propagation waits in Present until the authoritative nameservers serve
+	// the challenge record. It is nil when the wait is disabled.
+	propagation *propagationWaiter
+	// newDynuClient builds the Dynu API client for an API key. It defaults
+	// to dynu.NewClient and can be replaced to talk to a fake.
+	newDynuClient func(apiKey string, opts ...dynu.Option) dynu.Interface
+}
+
+func newDynuDNSProviderSolver() *dynuDNSProviderSolver {
+	return &dynuDNSProviderSolver{
+		options: defaultWebhookOptions(),
+	}
 }
 
 // customDNSProviderConfig is a structure that is used to decode into when
@@ -121,7 +104,130 @@ type dynuDNSProviderSolver struct {
 type dynuDNSProviderConfig struct {
 	// These fields will be set by users in the
 	// `issuer.spec.acme.dns01.providers.webhook.config` field.
+	// Version is the version of the config format, currently v1. It may be
+	// omitted.
+	Version string `json:"version,omitempty"`
+	// SecretRef is the name of the Secret holding the Dynu API key under
+	// api-key in the challenge's namespace.
 	SecretRef string `json:"secretName"`
+	// ApiKeySecretRef references the Secret key holding the Dynu API key. It
+	// replaces SecretRef, which always reads the key api-key from the
+	// challenge's namespace.
+	ApiKeySecretRef *secretKeySelector `json:"apiKeySecretRef,omitempty"`
+	// Zones selects other credentials for some zones. The entry with the
+	// longest zone matching the challenge applies; SecretRef or
+	// ApiKeySecretRef are the default for all other names.
+	Zones []zoneCredentials `json:"zones,omitempty"`
+	// DomainId pins the Dynu domain of the challenge records, skipping the
+	// discovery of the domain.
+	DomainId int `json:"domainId,omitempty"`
+	// ZoneName pins the Dynu domain by name. If DomainId is set as well, no
+	// Dynu API call is needed to find the domain.
+	ZoneName string `json:"zoneName,omitempty"`
+	// ApiUrl overrides the process-wide Dynu API base URL for this issuer. It
+	// must be listed in the --dynu-allowed-api-urls flag.
+	ApiUrl string `json:"apiUrl,omitempty"`
+	// MirrorRecord controls the extra TXT record written next to the
+	// challenge record.
+	MirrorRecord mirrorRecordConfig `json:"mirrorRecord,omitempty"`
+	// Ttl is the TTL in seconds of the TXT records. Defaults to 60.
+	Ttl int `json:"ttl,omitempty"`
+	// State enables or disables the TXT records in Dynu. Defaults to true.
+	State *bool `json:"state,omitempty"`
+	// Group is the Dynu group of the TXT records. It marks the records as
+	// owned by the webhook; only records in this group are ever deleted.
+	// Defaults to the --dynu-record-group flag.
+	Group string `json:"group,omitempty"`
+}
+
+// secretKeySelector references a key of a Secret.
+type secretKeySelector struct {
+	// Name is the name of the Secret.
+	Name string `json:"name"`
+	// Key is the key of the API key in the Secret data. Defaults to api-key.
+	Key string `json:"key,omitempty"`
+	// Namespace is the namespace of the Secret. Defaults to the namespace of
+	// the challenge, i.e. the cluster resource namespace of cert-manager for
+	// ClusterIssuers.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+const defaultApiKeySecretKey = "api-key"
+
+// referencesSecret reports whether cfg names a secret holding the API key.
+func (cfg dynuDNSProviderConfig) referencesSecret() bool {
+	return cfg.SecretRef != "" || cfg.ApiKeySecretRef != nil
+}
+
+// apiKeySecret returns the Secret key holding the Dynu API key for a
+// challenge in namespace.
+func (cfg dynuDNSProviderConfig) apiKeySecret(namespace string) secretKeySelector {
+	ref := secretKeySelector{Name: cfg.SecretRef}
+	if cfg.ApiKeySecretRef != nil {
+		ref = *cfg.ApiKeySecretRef
+	}
+	if ref.Key == "" {
+		ref.Key = defaultApiKeySecretKey
+	}
+	if ref.Namespace == "" {
+		ref.Namespace = namespace
+	}
+	return ref
+}
+
+const defaultTtl = 60
+
+// txtRecordRequest returns the record Present creates at recordName.
+func (cfg dynuDNSProviderConfig) txtRecordRequest(recordName string, key string) dynu.TxtRecordRequest {
+	ttl := cfg.Ttl
+	if ttl == 0 {
+		ttl = defaultTtl
+	}
+	state := true
+	if cfg.State != nil {
+		state = *cfg.State
+	}
+	return dynu.TxtRecordRequest{
+		NodeName: recordName,
+		Ttl:      strconv.Itoa(ttl),
+		Group:    cfg.Group,
+		State:    strconv.FormatBool(state),
+		TextData: key,
+	}
+}
+
+// mirrorRecordConfig configures the mirror TXT record. By default Present
+// writes the challenge key a second time at the record name without its
+// first label, e.g. at the apex for _acme-challenge.example.com. Disabling it
+// gives plain RFC 8555 behaviour.
+type mirrorRecordConfig struct {
+	// Enabled turns the mirror record on or off. Defaults to true.
+	Enabled *bool `json:"enabled,omitempty"`
+	// Name is the node name of the mirror record relative to the zone, "@"
+	// for the zone apex. Defaults to the challenge record name without its
+	// first label.
+	Name string `json:"name,omitempty"`
+}
+
+// recordNames returns the node names Present writes the challenge key to for
+// the challenge record recordName.
+func (cfg dynuDNSProviderConfig) recordNames(recordName string) []string {
+	names := []string{recordName}
+	if cfg.MirrorRecord.Enabled != nil && !*cfg.MirrorRecord.Enabled {
+		return names
+	}
+	mirrorName := determineBaseRecordName(recordName)
+	switch cfg.MirrorRecord.Name {
+	case "":
+	case "@":
+		mirrorName = ""
+	default:
+		mirrorName = cfg.MirrorRecord.Name
+	}
+	if !strings.EqualFold(mirrorName, recordName) {
+		names = append(names, mirrorName)
+	}
+	return names
 }
 
 // Name is used as the name for this DNS solver when referencing it on the ACME
@@ -140,41 +246,150 @@ func (c *dynuDNSProviderSolver) Name() string {
 // solver has correctly configured the DNS provider.
 func (c *dynuDNSProviderSolver) Present(ch *v1alpha1.ChallengeRequest) error {
 	klog.Infof("call function Present: ResourceNamespace=%s, ResolvedZone=%s, ResolvedFQDN=%s DNSName=%s", ch.ResourceNamespace, ch.ResolvedZone, ch.ResolvedFQDN, ch.DNSName)
+	// The API server fails the request after webhookRequestTimeout; work
+	// beyond that would only block the retry of cert-manager.
+	ctx, cancel := context.WithTimeout(context.Background(), webhookRequestTimeout)
+	defer cancel()
+	defer c.challengeLocks.Lock(challengeLockKey(ch))()
 
 	cfg, err := loadConfig(ch.Config)
 	if err != nil {
 		return err
 	}
+	cfg = c.withDefaults(cfg)
 	klog.Infof("Decoded configuration %v", cfg)
+	if err := c.checkConfig(cfg, ch); err != nil {
+		return err
+	}
 
-	secretName := cfg.SecretRef
-	sec, err := c.client.CoreV1().Secrets(ch.ResourceNamespace).Get(context.TODO(), secretName, metav1.GetOptions{})
+	apiKey, err := c.apiKey(ctx, cfg, ch)
 	if err != nil {
-		return fmt.Errorf("unable to get secret `%s/%s ; %v`", secretName, ch.ResourceNamespace, err)
+		return err
 	}
+	dynuClient := c.dynuClient(apiKey, cfg)
 
-	apiKey, err := stringFromSecretData(&sec.Data, "api-key")
+	records, err := presentTxtRecords(ctx, dynuClient, cfg, ch)
 	if err != nil {
-		return fmt.Errorf("unable to get api-key from secret %v", err)
+		return err
+	}
+	if c.store != nil && ch.UID != "" {
+		savedAt := time.Now().UTC()
+		records.SavedAt = &savedAt
+		if err := c.store.Save(ctx, records); err != nil {
+			// CleanUp falls back to searching the zone for the records.
+			klog.Errorf("Unable to store the records of challenge %s: %v", ch.UID, err)
+		}
+	}
+	if c.propagation != nil {
+		return c.propagation.Wait(ctx, propagationZone(ch), ch.ResolvedFQDN, ch.Key)
+	}
+	return nil
+}
+
+// propagationZone returns the zone whose nameservers are asked for the
+// challenge record. Without a resolved zone the lookup starts at the parent of
+// the challenge record and walks up.
+func propagationZone(ch *v1alpha1.ChallengeRequest) string {
+	if ch.ResolvedZone != "" {
+		return ch.ResolvedZone
 	}
+	_, parent, _ := strings.Cut(util.UnFqdn(ch.ResolvedFQDN), ".")
+	return parent
+}
 
-	domainId, recordName, err := getDomainIdFromFQDN(apiKey, ch.ResolvedFQDN)
+// presentTxtRecords creates the challenge TXT records. Records that already
+// exist with the challenge key are left alone, so repeated calls do not create
+// duplicates. Either all records are present afterwards or, if one of them
+// fails, the ones created by this call are removed again and the error is
+// returned.
+func presentTxtRecords(ctx context.Context, dynuClient dynu.Interface, cfg dynuDNSProviderConfig, ch *v1alpha1.ChallengeRequest) (challengeRecords, error) {
+	records := challengeRecords{
+		UID:  string(ch.UID),
+		FQDN: ch.ResolvedFQDN,
+		Key:  ch.Key,
+	}
+	domain, recordName, err := domainForChallenge(ctx, dynuClient, cfg, ch)
 	if err != nil {
-		return err
+		return records, err
 	}
+	domainId := domain.Id
+	records.DomainId = domainId
 
-	baseRecordName := determineBaseRecordName(recordName)
+	if err := checkTtlAgainstZone(cfg.Ttl, domain); err != nil {
+		return records, err
+	}
 
-	// For requested record
-	addTxtRecord(apiKey, domainId, recordName, ch)
-	// For record name without _acme-challenge as well (DNS propagation is checked through this name)
-	addTxtRecord(apiKey, domainId, baseRecordName, ch)
+	// The requested record, and unless disabled the mirror record as well
+	// (DNS propagation is checked through this name)
+	recordNames := cfg.recordNames(recordName)
+
+	zoneName := zoneNameFromRecordName(ch.ResolvedFQDN, recordName)
+	existing, err := listTxtRecords(ctx, dynuClient, domainId, zoneName, recordNames)
+	if err != nil {
+		return records, fmt.Errorf("unable to get DNS records %w", err)
+	}
+
+	var created []dynu.DnsRecord
+	for _, name := range recordNames {
+		if record, ok := findTxtRecord(existing, name, ch.Key, cfg.Group); ok {
+			klog.Infof("TXT record %d for %q already exists, skipping creation (challenge %s)", record.Id, name, ch.UID)
+			records.RecordIds = append(records.RecordIds, record.Id)
+			continue
+		}
+		record, err := addTxtRecord(ctx, dynuClient, domainId, name, cfg, ch)
+		if err != nil {
+			err = fmt.Errorf("unable to add TXT record %q: %w", name, err)
+			return records, rollbackTxtRecords(ctx, dynuClient, domainId, created, err)
+		}
+		created = append(created, record)
+		records.RecordIds = append(records.RecordIds, record.Id)
+		existing = append(existing, record)
+	}
 
 	klog.Infof("Presented txt record %v", ch.ResolvedFQDN)
 
+	return records, nil
+}
+
+// checkTtlAgainstZone rejects a configured record TTL longer than the TTL of
+// the zone, which only slows down validation. It checks nothing if the TTL of
+// the zone is unknown, so that it needs no API call of its own.
+func checkTtlAgainstZone(ttl int, domain dynu.Domain) error {
+	if ttl != 0 && domain.Ttl > 0 && ttl > domain.Ttl {
+		return fmt.Errorf("invalid solver config: ttl: %d is longer than the TTL %d of zone %s; use at most the zone TTL", ttl, domain.Ttl, domain.Name)
+	}
 	return nil
 }
 
+// findTxtRecord returns the TXT record at nodeName holding key that is owned
+// by the webhook.
+func findTxtRecord(records []dynu.DnsRecord, nodeName string, key string, group string) (dynu.DnsRecord, bool) {
+	for _, record := range records {
+		if record.RecordType == "TXT" && strings.EqualFold(record.NodeName, nodeName) && record.TextData == key && ownedRecord(record, group) {
+			return record, true
+		}
+	}
+	return dynu.DnsRecord{}, false
+}
+
+// ownedRecord reports whether record carries the ownership marker group, i.e.
+// whether it was created by the webhook.
+func ownedRecord(record dynu.DnsRecord, group string) bool {
+	return record.Group == group
+}
+
+// rollbackTxtRecords deletes records after cause made Present fail and returns
+// cause, extended by any rollback failure.
+func rollbackTxtRecords(ctx context.Context, dynuClient dynu.Interface, domainId int, records []dynu.DnsRecord, cause error) error {
+	for _, record := range records {
+		if rollbackErr := dynuClient.DeleteRecord(ctx, domainId, record.Id); rollbackErr != nil {
+			klog.Errorf("Unable to roll back TXT record %d for %q: %v", record.Id, record.NodeName, rollbackErr)
+			cause = fmt.Errorf("%w (rolling back TXT record %d failed: %v)", cause, record.Id, rollbackErr)
+		}
+	}
+	return cause
+}
+
 func determineBaseRecordName(recordName string) string {
 	klog.Infof("call function determineBaseRecordName: recordName=%s", recordName)
 	splitRecordName := strings.SplitN(recordName, ".", 2)
@@ -192,49 +407,183 @@ func determineBaseRecordName(recordName string) string {
 // This is in order to facilitate multiple DNS validations for the same domain
 // concurrently.
 func (c *dynuDNSProviderSolver) CleanUp(ch *v1alpha1.ChallengeRequest) error {
+	// The API server fails the request after webhookRequestTimeout; work
+	// beyond that would only block the retry of cert-manager.
+	ctx, cancel := context.WithTimeout(context.Background(), webhookRequestTimeout)
+	defer cancel()
+	defer c.challengeLocks.Lock(challengeLockKey(ch))()
+
 	cfg, err := loadConfig(ch.Config)
 	if err != nil {
 		return err
 	}
-	secretName := cfg.SecretRef
+	cfg = c.withDefaults(cfg)
+	if err := c.checkConfig(cfg, ch); err != nil {
+		return err
+	}
+
+	apiKey, err := c.apiKey(ctx, cfg, ch)
+	if err != nil {
+		return err
+	}
+	dynuClient := c.dynuClient(apiKey, cfg)
 
-	sec, err := c.client.CoreV1().Secrets(ch.ResourceNamespace).Get(context.TODO(), secretName, metav1.GetOptions{})
+	if c.store == nil || ch.UID == "" {
+		return cleanUpTxtRecords(ctx, dynuClient, cfg, ch)
+	}
+
+	records, ok, err := c.store.Get(ctx, string(ch.UID))
 	if err != nil {
-		return fmt.Errorf("unable to get secret `%s/%s ; %v`", secretName, ch.ResourceNamespace, err)
+		klog.Errorf("Unable to look up the stored records of challenge %s, searching the zone instead: %v", ch.UID, err)
+	}
+	if err == nil && ok && records.Key == ch.Key {
+		err = deleteStoredRecords(ctx, dynuClient, records)
+	} else {
+		err = cleanUpTxtRecords(ctx, dynuClient, cfg, ch)
 	}
-	apiKey, err := stringFromSecretData(&sec.Data, "api-key")
 	if err != nil {
-		return fmt.Errorf("unable to get api-key from secret `%s/%s` ; %v", secretName, ch.ResourceNamespace, err)
+		return err
+	}
+	// The entry is removed however the records were found, so that it does
+	// not outlive the challenge.
+	if err := c.store.Delete(ctx, string(ch.UID)); err != nil {
+		klog.Errorf("Unable to remove the stored records of challenge %s: %v", ch.UID, err)
 	}
+	return nil
+}
+
+// deleteStoredRecords deletes the records Present stored for a challenge by
+// their ID. Records that are already gone count as deleted.
+func deleteStoredRecords(ctx context.Context, dynuClient dynu.Interface, records challengeRecords) error {
+	var errs []error
+	for _, recordId := range records.RecordIds {
+		err := dynuClient.DeleteRecord(ctx, records.DomainId, recordId)
+		if errors.Is(err, dynu.ErrNotFound) {
+			klog.Infof("TXT record %d was already deleted", recordId)
+			continue
+		}
+		if err != nil {
+			klog.Error(err)
+			errs = append(errs, fmt.Errorf("unable to delete TXT record %d of %s: %w", recordId, records.FQDN, err))
+		}
+	}
+	return errors.Join(errs...)
+}
 
-	domainId, _, err := getDomainIdFromFQDN(apiKey, ch.ResolvedFQDN)
+// cleanUpTxtRecords deletes the challenge TXT records, i.e. the TXT records
+// in the webhook's group holding the challenge key at the node names Present
+// writes to. Records that are already gone count as deleted; all other
+// failures are collected and returned together so that cert-manager retries
+// the clean up.
+func cleanUpTxtRecords(ctx context.Context, dynuClient dynu.Interface, cfg dynuDNSProviderConfig, ch *v1alpha1.ChallengeRequest) error {
+	domain, recordName, err := domainForChallenge(ctx, dynuClient, cfg, ch)
 	if err != nil {
-		return fmt.Errorf("unable to retrieve domainId for domain name %s ; %v", ch.DNSName, err)
+		return fmt.Errorf("unable to retrieve domainId for domain name %s ; %w", ch.DNSName, err)
 	}
+	domainId := domain.Id
+	recordNames := cfg.recordNames(recordName)
 
-	dnsRecords, err := getRecordsForDomain(apiKey, domainId)
+	zoneName := zoneNameFromRecordName(ch.ResolvedFQDN, recordName)
+	dnsRecords, err := listTxtRecords(ctx, dynuClient, domainId, zoneName, recordNames)
 	if err != nil {
-		return fmt.Errorf("unable to get DNS records %v", err)
+		return fmt.Errorf("unable to get DNS records %w", err)
 	}
-	dnsRecordsResponse := DnsRecordResponse{}
-	readErr := json.Unmarshal(dnsRecords, &dnsRecordsResponse)
 
-	if readErr != nil {
-		return fmt.Errorf("unable to unmarshal response %v", readErr)
+	var errs []error
+	for i := len(dnsRecords) - 1; i >= 0; i-- {
+		record := dnsRecords[i]
+		if record.RecordType != "TXT" {
+			continue
+		}
+		ownName := containsFold(recordNames, record.NodeName)
+		ownKey := record.TextData == ch.Key
+		switch {
+		case ownName && ownKey && !ownedRecord(record, cfg.Group):
+			klog.Infof("Skipping TXT record %d at %q: it is in group %q instead of %q and was not created by the webhook", record.Id, record.NodeName, record.Group, cfg.Group)
+			continue
+		case ownName && ownKey:
+		case ownKey:
+			klog.Infof("Skipping TXT record %d at %q: it holds the challenge key but was not written for %s", record.Id, record.NodeName, ch.ResolvedFQDN)
+			continue
+		case ownName:
+			klog.Infof("Skipping TXT record %d at %q: its value does not match the challenge key", record.Id, record.NodeName)
+			continue
+		default:
+			continue
+		}
+
+		err := dynuClient.DeleteRecord(ctx, domainId, record.Id)
+		if errors.Is(err, dynu.ErrNotFound) {
+			klog.Infof("TXT record %d was already deleted", record.Id)
+			continue
+		}
+		if err != nil {
+			klog.Error(err)
+			errs = append(errs, fmt.Errorf("unable to delete TXT record %d (%s): %w", record.Id, record.NodeName, err))
+		}
 	}
 
-	for i := len(dnsRecordsResponse.DnsRecords) - 1; i >= 0; i-- {
-		klog.Infof("TXT entry with content %s (key value %s)", dnsRecordsResponse.DnsRecords[i].Content, ch.Key)
-		if dnsRecordsResponse.DnsRecords[i].RecordType == "TXT" && dnsRecordsResponse.DnsRecords[i].TextData == ch.Key {
-			deleteResponse, err := deleteTxtRecord(apiKey, domainId, dnsRecordsResponse.DnsRecords[i].Id)
-			if err != nil {
-				klog.Error(err)
+	return errors.Join(errs...)
+}
+
+// listTxtRecords returns the TXT records at nodeNames of the zone zoneName.
+// It looks up each hostname, which is much cheaper than listing a large zone,
+// and only falls back to listing all records of the domain when a hostname
+// lookup fails. A hostname without records is not a failure.
+func listTxtRecords(ctx context.Context, dynuClient dynu.Interface, domainId int, zoneName string, nodeNames []string) ([]dynu.DnsRecord, error) {
+	var records []dynu.DnsRecord
+	for _, nodeName := range nodeNames {
+		hostname := hostnameInZone(nodeName, zoneName)
+		found, err := dynuClient.GetRecordsByHostname(ctx, hostname, "TXT")
+		if errors.Is(err, dynu.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			klog.Infof("Unable to look up TXT records of %s, listing all records of domain %d instead: %v", hostname, domainId, err)
+			return dynuClient.GetRecords(ctx, domainId)
+		}
+		for _, record := range found {
+			// A hostname can also be served by a more specific Dynu domain.
+			if record.DomainId != 0 && record.DomainId != domainId {
+				continue
 			}
-			klog.Infof("Deleted TXT record result: %s", deleteResponse)
+			records = append(records, record)
 		}
 	}
+	return records, nil
+}
 
-	return nil
+// zoneNameFromRecordName returns the Dynu domain name of fqdn, given that
+// Dynu resolved fqdn to the node name recordName.
+func zoneNameFromRecordName(fqdn string, recordName string) string {
+	hostname := util.UnFqdn(fqdn)
+	if recordName == "" {
+		return hostname
+	}
+	if len(hostname) > len(recordName) && strings.EqualFold(hostname[:len(recordName)+1], recordName+".") {
+		return hostname[len(recordName)+1:]
+	}
+	return hostname
+}
+
+// hostnameInZone turns the node name nodeName of zone zoneName into a
+// hostname.
+func hostnameInZone(nodeName string, zoneName string) string {
+	if nodeName == "" {
+		return zoneName
+	}
+	return nodeName + "." + zoneName
+}
+
+// containsFold reports whether names contains name, ignoring case like DNS
+// does.
+func containsFold(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
 }
 
 // Initialize will be called when the webhook first starts.
@@ -254,38 +603,108 @@ func (c *dynuDNSProviderSolver) Initialize(kubeClientConfig *rest.Config, stopCh
 	}
 
 	c.client = cl
+	c.secrets = newSecretCache(cl, stopCh, c.forgetApiKey)
+	c.ambient = newAmbientCredentials(c.options.AmbientApiKeyFile, ambientApiKeyEnv, c.forgetApiKey)
+
+	if err := c.options.Validate(); err != nil {
+		return err
+	}
+	c.rateLimiters = dynu.NewRateLimiters(c.options.RateLimit, c.options.RateBurst)
+	c.httpClient = dynu.NewHTTPClient(c.options.HTTP)
+	if c.options.StoreNamespace != "" {
+		c.store = newConfigMapRecordStore(cl, c.options.StoreNamespace, c.options.StoreConfigMap)
+	}
+	if c.options.PropagationTimeout > 0 {
+		c.propagation, err = newPropagationWaiter(c.options.PropagationTimeout, c.options.PropagationInterval, c.options.PropagationResolvers)
+		if err != nil {
+			return err
+		}
+	}
+	// The garbage collector is started last, so that it never runs for a
+	// solver that failed to initialise.
+	if c.options.GCInterval > 0 {
+		gc, err := c.newGarbageCollector(kubeClientConfig)
+		if err != nil {
+			return err
+		}
+		go gc.Run(stopCh)
+	}
 
 	return nil
 }
 
-// loadConfig is a small helper function that decodes JSON configuration into
-// the typed config struct.
-func loadConfig(cfgJSON *extapi.JSON) (dynuDNSProviderConfig, error) {
-	cfg := dynuDNSProviderConfig{}
-	// handle the 'base case' where no configuration has been provided
-	if cfgJSON == nil {
-		return cfg, nil
+// withDefaults fills in the process-wide defaults for settings cfg leaves
+// empty.
+func (c *dynuDNSProviderSolver) withDefaults(cfg dynuDNSProviderConfig) dynuDNSProviderConfig {
+	if cfg.Group == "" {
+		cfg.Group = c.options.RecordGroup
 	}
-	if err := json.Unmarshal(cfgJSON.Raw, &cfg); err != nil {
-		return cfg, fmt.Errorf("error decoding solver config: %v", err)
+	return cfg
+}
+
+// checkConfig rejects the settings of cfg that the webhook administrator has
+// not allowed issuers to use for the challenge ch.
+func (c *dynuDNSProviderSolver) checkConfig(cfg dynuDNSProviderConfig, ch *v1alpha1.ChallengeRequest) error {
+	if cfg.ApiUrl != "" && !c.options.apiUrlAllowed(cfg.ApiUrl) {
+		return fmt.Errorf("apiUrl %q is not allowed by the webhook: the API key would be sent to it; list it in --dynu-allowed-api-urls to allow it", cfg.ApiUrl)
+	}
+	if c.options.AllowCrossNamespaceSecrets {
+		return nil
+	}
+	// An issuer must not use the API keys of other namespaces, which would
+	// let it write records to their zones.
+	refs := map[string]*secretKeySelector{"apiKeySecretRef": cfg.ApiKeySecretRef}
+	for i, zone := range cfg.Zones {
+		refs[fmt.Sprintf("zones[%d].apiKeySecretRef", i)] = zone.ApiKeySecretRef
+	}
+	var problems []string
+	for field, ref := range refs {
+		if ref != nil && ref.Namespace != "" && ref.Namespace != ch.ResourceNamespace {
+			problems = append(problems, fmt.Sprintf("%s.namespace %q", field, ref.Namespace))
+		}
 	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%s: secrets outside the challenge namespace %q are not allowed by the webhook; start it with --allow-cross-namespace-secrets to allow them", strings.Join(problems, ", "), ch.ResourceNamespace)
+	}
+	return nil
+}
 
-	return cfg, nil
+// forgetApiKey drops the data cached for a Dynu API key that is no longer
+// used, e.g. after the key was rotated.
+func (c *dynuDNSProviderSolver) forgetApiKey(apiKey string) {
+	if c.rateLimiters != nil {
+		c.rateLimiters.Forget(apiKey)
+	}
 }
 
-func getDomainIdFromFQDN(apiKey string, ResolvedFQDN string) (string, string, error) {
-	klog.Infof("call function getDomainIdFromFQDN: apiKey=%s, ResolvedFQDN=%s", apiKey, ResolvedFQDN)
-	hostname := util.UnFqdn(ResolvedFQDN)
-	url := apiUrl + "/dns/getroot/" + hostname
-	response, err := callDnsApi(url, "GET", nil, apiKey)
-	if err != nil {
-		return "", "", err
+// dynuClient returns the Dynu API client to use for apiKey, honouring the
+// per-issuer overrides in cfg, which checkConfig must have allowed.
+func (c *dynuDNSProviderSolver) dynuClient(apiKey string, cfg dynuDNSProviderConfig) dynu.Interface {
+	apiUrl := c.options.ApiUrl
+	if cfg.ApiUrl != "" {
+		apiUrl = cfg.ApiUrl
+	}
+	opts := []dynu.Option{
+		dynu.WithApiUrl(apiUrl),
+		dynu.WithRetryPolicy(c.options.Retry),
+		dynu.WithHTTPClient(c.httpClient),
+	}
+	if c.rateLimiters != nil {
+		opts = append(opts, dynu.WithRateLimiter(c.rateLimiters.For(apiKey)))
 	}
+	if c.newDynuClient != nil {
+		return c.newDynuClient(apiKey, opts...)
+	}
+	return dynu.NewClient(apiKey, opts...)
+}
 
-	dnsRootResponse := DNSRootResponse{}
-	readErr := json.Unmarshal(response, &dnsRootResponse)
-	if readErr != nil {
-		return "", "", readErr
+func getDomainIdFromFQDN(ctx context.Context, dynuClient dynu.Interface, ResolvedFQDN string) (int, string, error) {
+	klog.Infof("call function getDomainIdFromFQDN: ResolvedFQDN=%s", ResolvedFQDN)
+	hostname := util.UnFqdn(ResolvedFQDN)
+	dnsRootResponse, err := dynuClient.GetRoot(ctx, hostname)
+	if err != nil {
+		return 0, "", err
 	}
 
 	// set root response domain values as default
@@ -296,7 +715,10 @@ func getDomainIdFromFQDN(apiKey string, ResolvedFQDN string) (string, string, er
 	if strings.Contains(dnsRootResponse.Node, ".") {
 		klog.Infof("Return node name shows that a subdomain could have been specified: Node=%s", dnsRootResponse.Node)
 
-		subFound, subResponse := getSubDomainId(apiKey, ResolvedFQDN)
+		subFound, subResponse, err := getSubDomainId(ctx, dynuClient, ResolvedFQDN)
+		if err != nil {
+			return 0, "", err
+		}
 		if subFound {
 			domainId = subResponse.Id
 			domainNode = subResponse.Node
@@ -304,132 +726,73 @@ func getDomainIdFromFQDN(apiKey string, ResolvedFQDN string) (string, string, er
 	}
 
 	klog.Infof("Domain Detail: id=%s, node=%s", strconv.Itoa(domainId), domainNode)
-	return fmt.Sprint(domainId), domainNode, nil
+	return domainId, domainNode, nil
 }
 
 // Function looks for the top level sub domain name
-func getSubDomainId(apiKey string, fqdn string) (bool, DNSSubResponse) {
-	matchName := false
+func getSubDomainId(ctx context.Context, dynuClient dynu.Interface, fqdn string) (bool, DNSSubResponse, error) {
 	subResponse := DNSSubResponse{}
 
 	// get a list of the domains for the API key to check for subdomain match
-	domainRecords, err := getDomains(apiKey)
+	domains, err := dynuClient.GetDomains(ctx)
 	if err != nil {
 		klog.Infof("unable to get Domain records %v", err)
-		return matchName, subResponse
-	}
-	domainRecordsResponse := DomainRecordResponse{}
-	readErr := json.Unmarshal(domainRecords, &domainRecordsResponse)
-
-	if readErr != nil {
-		klog.Infof("unable to unmarshal response %v", readErr)
-		return matchName, subResponse
-	}
-
-	parts := strings.Split(fqdn, ".")
-	for i := range parts {
-		domain := strings.Join(parts[i:], ".")
-		domain = strings.TrimSuffix(domain, ".")
-		for k, record := range domainRecordsResponse.Domains {
-			klog.Infof("Checking domain %s with subdmain %s", record.Name, domain)
-			if record.Name == domain {
-				klog.Infof("Subdomain match found %v", k)
-				fqdn = strings.TrimSuffix(fqdn, ".")
-				subResponse.Id = record.Id
-				subResponse.Node = strings.TrimSuffix(fqdn, "." + record.Name)
-				return true, subResponse
-			}
-		}
+		return false, subResponse, nil
 	}
 
-	if !matchName {
-		klog.Infof("Sub domain match not found")
+	domain, ok, err := resolveDomain(domains, fqdn)
+	if err != nil {
+		return false, subResponse, err
 	}
-
-	return matchName, subResponse
-}
-
-func stringFromSecretData(secretData *map[string][]byte, key string) (string, error) {
-	data, ok := (*secretData)[key]
 	if !ok {
-		return "", fmt.Errorf("key %q not found in secret data", key)
+		klog.Infof("Sub domain match not found")
+		return false, subResponse, nil
 	}
-	return string(data), nil
+	klog.Infof("Subdomain match found %s (ID %d)", domain.Name, domain.Id)
+	subResponse.Id = domain.Id
+	subResponse.DomainName = domain.Name
+	subResponse.Node = recordNameInZone(fqdn, domain.Name)
+	return true, subResponse, nil
 }
 
-func addTxtRecord(apiKey string, domainId string, recordName string, ch *v1alpha1.ChallengeRequest) {
-	requestbody := map[string]string{
-		"nodeName":   recordName,
-		"recordType": "TXT",
-		"ttl":        "60",
-		"group":      "",
-		"state":      "true",
-		"textData":   ch.Key}
-	jsonBody, _ := json.Marshal(requestbody)
-	url := apiUrl + "/dns/" + domainId + "/record"
-	response, err := callDnsApi(url, "POST", bytes.NewBuffer(jsonBody), apiKey)
-
-	if err != nil {
-		klog.Error(err)
+// apiKey returns the Dynu API key for a challenge: the one in the secret
+// referenced by the zones entry of cfg matching the challenge, else by cfg
+// itself or, if there is none, the ambient API key.
+func (c *dynuDNSProviderSolver) apiKey(ctx context.Context, cfg dynuDNSProviderConfig, ch *v1alpha1.ChallengeRequest) (string, error) {
+	cfg = cfg.forFQDN(ch.ResolvedFQDN)
+	if cfg.referencesSecret() {
+		return readApiKey(ctx, c.secrets, cfg.apiKeySecret(ch.ResourceNamespace))
 	}
-	klog.Infof("Added TXT record result: %s", string(response))
-}
-
-// Get a list of the Domains associated with the API to allow for an enumerated check (DYNU API does not have any subdomain filtering)
-func getDomains(apiKey string) ([]byte, error) {
-	url := apiUrl + "/dns"
-	response, err := callDnsApi(url, "GET", nil, apiKey)
-
-	return response, err
-}
-
-func getRecordsForDomain(apiKey string, domainId string) ([]byte, error) {
-	url := apiUrl + "/dns/" + domainId + "/record"
-	response, err := callDnsApi(url, "GET", nil, apiKey)
-
-	return response, err
-}
-
-func deleteTxtRecord(apiKey string, domainId string, recordId int) (string, error) {
-	url := apiUrl + "/dns/" + domainId + "/record/" + fmt.Sprint(recordId)
-	response, err := callDnsApi(url, "DELETE", nil, apiKey)
-
-	return string(response), err
+	if !ch.AllowAmbientCredentials {
+		return "", fmt.Errorf("no API key secret configured: set secretName or apiKeySecretRef in the solver config; ambient credentials are not allowed for this issuer")
+	}
+	if c.ambient == nil {
+		return "", fmt.Errorf("no API key secret configured and the webhook has no ambient API key: set secretName or apiKeySecretRef in the solver config, or %s or --ambient-api-key-file on the webhook", ambientApiKeyEnv)
+	}
+	return c.ambient.ApiKey()
 }
 
-func callDnsApi(url string, method string, body io.Reader, apiKey string) ([]byte, error) {
-	req, err := http.NewRequest(method, url, body)
+// readApiKey reads the Dynu API key referenced by ref.
+func readApiKey(ctx context.Context, secrets secretGetter, ref secretKeySelector) (string, error) {
+	sec, err := secrets.GetSecret(ctx, ref.Namespace, ref.Name)
 	if err != nil {
-		return []byte{}, fmt.Errorf("unable to execute request %v", err)
-	}
-	req.Close = true
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("API-Key", apiKey)
-	t := &http.Transport{
-		TLSHandshakeTimeout: 60 * time.Second,
+		return "", fmt.Errorf("unable to get secret `%s/%s`: %v", ref.Namespace, ref.Name, err)
 	}
-	client := &http.Client{
-		Transport: t,
-	}
-	resp, err := client.Do(req)
+	apiKey, err := stringFromSecretData(&sec.Data, ref.Key)
 	if err != nil {
-		klog.Errorf("Failed to Do request")
-		return nil, err
+		return "", fmt.Errorf("unable to get the API key from secret `%s/%s`: %v", ref.Namespace, ref.Name, err)
 	}
+	return apiKey, nil
+}
 
-	defer resp.Body.Close()
-
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		klog.Error(err)
-		return nil, err
-	}
-	if resp.StatusCode == http.StatusOK {
-		return respBody, nil
+func stringFromSecretData(secretData *map[string][]byte, key string) (string, error) {
+	data, ok := (*secretData)[key]
+	if !ok {
+		return "", fmt.Errorf("key %q not found in secret data", key)
 	}
+	return string(data), nil
+}
 
-	text := "Error calling API status:" + resp.Status + " url: " + url + " method: " + method
-	klog.Error(text)
-	return nil, errors.New(text)
+func addTxtRecord(ctx context.Context, dynuClient dynu.Interface, domainId int, recordName string, cfg dynuDNSProviderConfig, ch *v1alpha1.ChallengeRequest) (dynu.DnsRecord, error) {
+	return dynuClient.AddTxtRecord(ctx, domainId, cfg.txtRecordRequest(recordName, ch.Key))
 }
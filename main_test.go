@@ -34,7 +34,7 @@ func TestRunsSuite(t *testing.T) {
 	//	acmetest.SetManifestPath("testdata/my-custom-solver"),
 	//	acmetest.SetBinariesPath("_test/kubebuilder/bin"),
 	//)
-	fixture := acmetest.NewFixture(&dynuDNSProviderSolver{},
+	fixture := acmetest.NewFixture(newDynuDNSProviderSolver(),
 		acmetest.SetResolvedZone(zone),
 		acmetest.SetAllowAmbientCredentials(false),
 		acmetest.SetUseAuthoritative(true),
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/Dopingus/cert-manager-webhook-dynu/dynu"
+)
+
+// webhookRequestTimeout is how long the webhook's API server lets a Present or
+// CleanUp request run before it fails the request. It is the default of
+// k8s.io/apiserver, which the webhook serving library does not let us change.
+const webhookRequestTimeout = 60 * time.Second
+
+// maxPropagationTimeout is the longest propagation wait that leaves Present
+// enough of webhookRequestTimeout for its Dynu API calls.
+const maxPropagationTimeout = 40 * time.Second
+
+// webhookOptions holds the process-wide settings of the webhook. They are
+// set through command line flags, falling back to environment variables.
+type webhookOptions struct {
+	// ApiUrl is the Dynu API base URL used unless an issuer overrides it.
+	ApiUrl string
+	// AllowedApiUrls are the Dynu API base URLs issuers may select with
+	// apiUrl. The API key is sent to that URL, so an issuer can only override
+	// ApiUrl with a URL the administrator listed here. Empty disallows the
+	// override.
+	AllowedApiUrls []string
+	// Retry controls how failed Dynu API calls are retried.
+	Retry dynu.RetryPolicy
+	// RateLimit is the number of Dynu API requests per second allowed per
+	// API key. 0 disables client-side rate limiting.
+	RateLimit float64
+	// RateBurst is the number of requests per API key that may be sent at
+	// once before RateLimit applies.
+	RateBurst int
+	// HTTP configures the pooled HTTP client shared by all Dynu API calls.
+	HTTP dynu.HTTPClientOptions
+	// RecordGroup is the Dynu group marking records created by the webhook,
+	// unless an issuer configures its own.
+	RecordGroup string
+	// StoreNamespace is the namespace of the ConfigMap recording the records
+	// created per challenge. Empty disables the record store.
+	StoreNamespace string
+	// StoreConfigMap is the name of that ConfigMap.
+	StoreConfigMap string
+	// GCInterval is the time between two garbage collection runs deleting
+	// orphaned challenge records. 0 disables the garbage collector.
+	GCInterval time.Duration
+	// GCMinAge is the minimum age of a record before it is collected.
+	GCMinAge time.Duration
+	// GCDryRun only logs the records the garbage collector would delete.
+	GCDryRun bool
+	// GCSecrets are the namespace/name references of the Secrets whose API
+	// keys give access to the domains the garbage collector scans.
+	GCSecrets []string
+	// GCGroups are the Dynu groups whose records the garbage collector
+	// deletes, i.e. RecordGroup and the groups issuers configure themselves.
+	// Empty collects RecordGroup only.
+	GCGroups []string
+	// PropagationTimeout is the maximum time Present waits for the
+	// authoritative nameservers to serve the challenge record. 0 disables
+	// the wait. It is at most maxPropagationTimeout.
+	PropagationTimeout time.Duration
+	// PropagationInterval is the time between two polls of the nameservers.
+	PropagationInterval time.Duration
+	// PropagationResolvers are the recursive nameservers (host:port) used to
+	// look up the nameservers of a zone. Empty uses /etc/resolv.conf.
+	PropagationResolvers []string
+	// AmbientApiKeyFile is the file holding the API key used for challenges
+	// that reference no secret, if cert-manager allows ambient credentials.
+	// Empty falls back to the DYNU_API_KEY environment variable.
+	AmbientApiKeyFile string
+	// AllowCrossNamespaceSecrets lets solver configs reference API key
+	// secrets outside the namespace of the challenge with
+	// apiKeySecretRef.namespace.
+	AllowCrossNamespaceSecrets bool
+}
+
+func defaultWebhookOptions() webhookOptions {
+	return webhookOptions{
+		ApiUrl:         envOrDefault("DYNU_API_URL", dynu.DefaultApiUrl),
+		Retry:          dynu.DefaultRetryPolicy(),
+		RateLimit:      2,
+		RateBurst:      5,
+		HTTP:           dynu.DefaultHTTPClientOptions(),
+		RecordGroup:    "cert-manager",
+		StoreNamespace: os.Getenv("POD_NAMESPACE"),
+		StoreConfigMap: "dynu-webhook-records",
+		GCMinAge:       time.Hour,
+
+		PropagationInterval: 5 * time.Second,
+		AmbientApiKeyFile:   os.Getenv("DYNU_API_KEY_FILE"),
+	}
+}
+
+// AddFlags registers the webhook options on fs.
+func (o *webhookOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.ApiUrl, "dynu-api-url", o.ApiUrl, "Base URL of the Dynu API. Can also be set with DYNU_API_URL.")
+	fs.StringSliceVar(&o.AllowedApiUrls, "dynu-allowed-api-urls", o.AllowedApiUrls, "Dynu API base URLs issuers may select with apiUrl in their solver config. Empty disallows the override.")
+	fs.IntVar(&o.Retry.MaxAttempts, "dynu-retry-max-attempts", o.Retry.MaxAttempts, "Maximum number of attempts per Dynu API call, including the first one.")
+	fs.DurationVar(&o.Retry.InitialBackoff, "dynu-retry-initial-backoff", o.Retry.InitialBackoff, "Wait before the first retry of a Dynu API call; doubled for every further retry.")
+	fs.DurationVar(&o.Retry.MaxBackoff, "dynu-retry-max-backoff", o.Retry.MaxBackoff, "Maximum wait between two attempts of a Dynu API call.")
+	fs.DurationVar(&o.Retry.MaxElapsed, "dynu-retry-deadline", o.Retry.MaxElapsed, "Overall time budget of a Dynu API call including retries. 0 disables the budget.")
+	fs.Float64Var(&o.RateLimit, "dynu-rate-limit", o.RateLimit, "Dynu API requests per second allowed per API key. 0 disables rate limiting.")
+	fs.IntVar(&o.RateBurst, "dynu-rate-burst", o.RateBurst, "Dynu API requests per API key that may be sent at once.")
+	fs.DurationVar(&o.HTTP.DialTimeout, "dynu-dial-timeout", o.HTTP.DialTimeout, "Timeout for connecting to the Dynu API.")
+	fs.DurationVar(&o.HTTP.TLSHandshakeTimeout, "dynu-tls-handshake-timeout", o.HTTP.TLSHandshakeTimeout, "Timeout for the TLS handshake with the Dynu API.")
+	fs.DurationVar(&o.HTTP.ResponseHeaderTimeout, "dynu-response-header-timeout", o.HTTP.ResponseHeaderTimeout, "Timeout for receiving the response headers of a Dynu API call.")
+	fs.DurationVar(&o.HTTP.Timeout, "dynu-request-timeout", o.HTTP.Timeout, "Overall timeout of a single Dynu API request attempt.")
+	fs.StringVar(&o.RecordGroup, "dynu-record-group", o.RecordGroup, "Dynu group set on every record the webhook creates. Only records in this group are deleted.")
+	fs.StringVar(&o.StoreNamespace, "record-store-namespace", o.StoreNamespace, "Namespace of the ConfigMap recording the Dynu records created per challenge. Defaults to POD_NAMESPACE; empty disables the store.")
+	fs.StringVar(&o.StoreConfigMap, "record-store-configmap", o.StoreConfigMap, "Name of the ConfigMap recording the Dynu records created per challenge.")
+	fs.DurationVar(&o.GCInterval, "gc-interval", o.GCInterval, "Interval of the garbage collector deleting orphaned challenge TXT records. 0 disables it.")
+	fs.DurationVar(&o.GCMinAge, "gc-min-age", o.GCMinAge, "Minimum age of a TXT record before the garbage collector deletes it.")
+	fs.BoolVar(&o.GCDryRun, "gc-dry-run", o.GCDryRun, "Only log the TXT records the garbage collector would delete.")
+	fs.StringSliceVar(&o.GCGroups, "gc-groups", o.GCGroups, "Dynu groups whose orphaned TXT records the garbage collector deletes. Defaults to --dynu-record-group; list the groups issuers set in their solver config as well.")
+	fs.StringSliceVar(&o.GCSecrets, "gc-secrets", o.GCSecrets, "Secrets (namespace/name[:key], key defaulting to api-key) holding the Dynu API keys of the domains the garbage collector scans.")
+	fs.DurationVar(&o.PropagationTimeout, "propagation-timeout", o.PropagationTimeout, "Maximum time Present waits until all authoritative nameservers serve the challenge record, at most 40s. 0 disables the wait.")
+	fs.DurationVar(&o.PropagationInterval, "propagation-interval", o.PropagationInterval, "Time between two queries of the authoritative nameservers while waiting for propagation.")
+	fs.StringSliceVar(&o.PropagationResolvers, "propagation-resolvers", o.PropagationResolvers, "Recursive nameservers (host:port) used to look up the nameservers of a zone. Defaults to /etc/resolv.conf.")
+	fs.BoolVar(&o.AllowCrossNamespaceSecrets, "allow-cross-namespace-secrets", o.AllowCrossNamespaceSecrets, "Allow solver configs to read API key secrets from other namespaces than the challenge's with apiKeySecretRef.namespace. Any issuer can then use the API keys of the secrets the webhook may read.")
+	fs.StringVar(&o.AmbientApiKeyFile, "ambient-api-key-file", o.AmbientApiKeyFile, "File holding the Dynu API key for challenges that reference no secret, if ambient credentials are allowed. It is re-read when it changes. Can also be set with DYNU_API_KEY_FILE; without it DYNU_API_KEY is used.")
+}
+
+// Validate checks the webhook options for invalid values.
+func (o *webhookOptions) Validate() error {
+	if err := dynu.ValidateApiUrl(o.ApiUrl); err != nil {
+		return err
+	}
+	for _, apiUrl := range o.AllowedApiUrls {
+		if err := dynu.ValidateApiUrl(apiUrl); err != nil {
+			return fmt.Errorf("--dynu-allowed-api-urls: %w", err)
+		}
+	}
+	if o.Retry.MaxAttempts < 1 {
+		return fmt.Errorf("--dynu-retry-max-attempts must be at least 1, got %d", o.Retry.MaxAttempts)
+	}
+	if o.Retry.InitialBackoff < 0 || o.Retry.MaxBackoff < 0 || o.Retry.MaxElapsed < 0 {
+		return fmt.Errorf("Dynu retry durations must not be negative")
+	}
+	if o.Retry.MaxBackoff < o.Retry.InitialBackoff {
+		return fmt.Errorf("--dynu-retry-max-backoff (%v) must not be lower than --dynu-retry-initial-backoff (%v)", o.Retry.MaxBackoff, o.Retry.InitialBackoff)
+	}
+	if o.RateLimit < 0 {
+		return fmt.Errorf("--dynu-rate-limit must not be negative, got %v", o.RateLimit)
+	}
+	if o.RateBurst < 1 {
+		return fmt.Errorf("--dynu-rate-burst must be at least 1, got %d", o.RateBurst)
+	}
+	if o.RecordGroup == "" {
+		return fmt.Errorf("--dynu-record-group must not be empty")
+	}
+	if o.StoreNamespace != "" && o.StoreConfigMap == "" {
+		return fmt.Errorf("--record-store-configmap must not be empty")
+	}
+	if o.HTTP.DialTimeout <= 0 || o.HTTP.TLSHandshakeTimeout <= 0 || o.HTTP.ResponseHeaderTimeout <= 0 || o.HTTP.Timeout <= 0 {
+		return fmt.Errorf("Dynu HTTP timeouts must be positive")
+	}
+	if o.GCInterval < 0 {
+		return fmt.Errorf("--gc-interval must not be negative, got %v", o.GCInterval)
+	}
+	if o.GCInterval > 0 {
+		if o.GCMinAge <= 0 {
+			return fmt.Errorf("--gc-min-age must be positive, got %v", o.GCMinAge)
+		}
+		if len(o.GCSecrets) == 0 {
+			return fmt.Errorf("--gc-secrets must not be empty when the garbage collector is enabled")
+		}
+		if _, err := parseSecretRefs(o.GCSecrets); err != nil {
+			return fmt.Errorf("--gc-secrets: %w", err)
+		}
+		for _, group := range o.GCGroups {
+			if group == "" {
+				return fmt.Errorf("--gc-groups must not contain an empty group")
+			}
+		}
+	}
+	if o.PropagationTimeout < 0 {
+		return fmt.Errorf("--propagation-timeout must not be negative, got %v", o.PropagationTimeout)
+	}
+	if o.PropagationTimeout > maxPropagationTimeout {
+		return fmt.Errorf("--propagation-timeout must be at most %v to leave Present time for the Dynu API within the %v request timeout of the webhook, got %v", maxPropagationTimeout, webhookRequestTimeout, o.PropagationTimeout)
+	}
+	if o.PropagationTimeout > 0 && o.PropagationInterval <= 0 {
+		return fmt.Errorf("--propagation-interval must be positive, got %v", o.PropagationInterval)
+	}
+	for _, resolver := range o.PropagationResolvers {
+		if _, _, err := net.SplitHostPort(resolver); err != nil {
+			return fmt.Errorf("--propagation-resolvers: invalid nameserver %q, expected host:port", resolver)
+		}
+	}
+	return nil
+}
+
+// apiUrlAllowed reports whether an issuer may send its API key to apiUrl,
+// i.e. whether apiUrl is the process-wide URL or one of AllowedApiUrls.
+func (o *webhookOptions) apiUrlAllowed(apiUrl string) bool {
+	apiUrl = strings.TrimSuffix(apiUrl, "/")
+	if apiUrl == strings.TrimSuffix(o.ApiUrl, "/") {
+		return true
+	}
+	for _, allowed := range o.AllowedApiUrls {
+		if apiUrl == strings.TrimSuffix(allowed, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+func envOrDefault(key string, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return defaultValue
+}
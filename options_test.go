@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookOptions_Validate(t *testing.T) {
+	o := defaultWebhookOptions()
+	assert.NoError(t, o.Validate())
+
+	o = defaultWebhookOptions()
+	o.ApiUrl = "not a url"
+	assert.Error(t, o.Validate())
+
+	o = defaultWebhookOptions()
+	o.AllowedApiUrls = []string{"dynu-stub:8080"}
+	assert.Error(t, o.Validate())
+
+	o = defaultWebhookOptions()
+	o.Retry.MaxAttempts = 0
+	assert.Error(t, o.Validate())
+
+	o = defaultWebhookOptions()
+	o.Retry.MaxBackoff = time.Millisecond
+	assert.Error(t, o.Validate())
+
+	o = defaultWebhookOptions()
+	o.HTTP.Timeout = 0
+	assert.Error(t, o.Validate())
+
+	o = defaultWebhookOptions()
+	o.GCInterval = time.Hour
+	assert.Error(t, o.Validate())
+	o.GCSecrets = []string{"cert-manager/dynu-secret"}
+	assert.NoError(t, o.Validate())
+	o.GCSecrets = []string{"dynu-secret"}
+	assert.Error(t, o.Validate())
+	o.GCSecrets = []string{"cert-manager/dynu-secret"}
+	o.GCGroups = []string{"cert-manager", ""}
+	assert.Error(t, o.Validate())
+
+	o = defaultWebhookOptions()
+	o.PropagationTimeout = 30 * time.Second
+	o.PropagationResolvers = []string{"1.1.1.1:53"}
+	assert.NoError(t, o.Validate())
+	o.PropagationResolvers = []string{"1.1.1.1"}
+	assert.Error(t, o.Validate())
+
+	// Longer waits would outlast the request timeout of the webhook.
+	o = defaultWebhookOptions()
+	o.PropagationTimeout = time.Minute
+	assert.ErrorContains(t, o.Validate(), "--propagation-timeout must be at most 40s")
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"k8s.io/klog"
+)
+
+// exchangeFunc sends a DNS query to server (host:port) and returns the reply.
+type exchangeFunc func(ctx context.Context, m *dns.Msg, server string) (*dns.Msg, error)
+
+// propagationWaiter waits until the authoritative nameservers of a zone serve
+// a TXT record, so that cert-manager's self check does not run against
+// nameservers that have not picked up the record yet.
+type propagationWaiter struct {
+	// timeout is the maximum time to wait. The wait gives up silently
+	// afterwards and leaves it to the self check to retry.
+	timeout time.Duration
+	// interval is the time between two polls of the nameservers.
+	interval time.Duration
+	// resolvers are the recursive nameservers (host:port) used to look up
+	// the NS records of the zone.
+	resolvers []string
+
+	exchange exchangeFunc
+}
+
+func newPropagationWaiter(timeout time.Duration, interval time.Duration, resolvers []string) (*propagationWaiter, error) {
+	if len(resolvers) == 0 {
+		config, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+		if err != nil {
+			return nil, fmt.Errorf("unable to read recursive nameservers: %w", err)
+		}
+		for _, server := range config.Servers {
+			resolvers = append(resolvers, net.JoinHostPort(server, config.Port))
+		}
+	}
+	return &propagationWaiter{
+		timeout:   timeout,
+		interval:  interval,
+		resolvers: resolvers,
+		exchange:  exchangeDNS,
+	}, nil
+}
+
+// exchangeDNS queries server over UDP, repeating the query over TCP when the
+// reply was truncated.
+func exchangeDNS(ctx context.Context, m *dns.Msg, server string) (*dns.Msg, error) {
+	client := &dns.Client{Net: "udp", Timeout: 5 * time.Second}
+	reply, _, err := client.ExchangeContext(ctx, m, server)
+	if err == nil && reply.Truncated {
+		client.Net = "tcp"
+		reply, _, err = client.ExchangeContext(ctx, m, server)
+	}
+	return reply, err
+}
+
+// Wait blocks until every authoritative nameserver of zone serves value in a
+// TXT record at fqdn, or the timeout expires. It only returns an error when
+// ctx is cancelled.
+func (w *propagationWaiter) Wait(ctx context.Context, zone string, fqdn string, value string) error {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	var pending []string
+	for {
+		nameservers, err := w.nameservers(ctx, zone)
+		if err != nil {
+			klog.Infof("Unable to look up the nameservers of %s: %v", zone, err)
+		} else {
+			pending = pending[:0]
+			for _, ns := range nameservers {
+				ok, err := w.serves(ctx, ns, fqdn, value)
+				if err != nil {
+					klog.V(4).Infof("Unable to query %s for %s: %v", ns, fqdn, err)
+				}
+				if !ok {
+					pending = append(pending, ns)
+				}
+			}
+			if len(pending) == 0 {
+				klog.Infof("TXT record %s is served by all nameservers of %s", fqdn, zone)
+				return nil
+			}
+			klog.V(4).Infof("TXT record %s is not yet served by %s", fqdn, strings.Join(pending, ", "))
+		}
+
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				klog.Warningf("TXT record %s was not served by all nameservers of %s after %v, still missing at %s", fqdn, zone, w.timeout, strings.Join(pending, ", "))
+				return nil
+			}
+			return ctx.Err()
+		case <-time.After(w.interval):
+		}
+	}
+}
+
+// nameservers returns the host names of the authoritative nameservers of
+// zone, as published in its NS records. Names without NS records are walked
+// up towards the root.
+func (w *propagationWaiter) nameservers(ctx context.Context, zone string) ([]string, error) {
+	name := dns.Fqdn(strings.ToLower(zone))
+	for {
+		m := &dns.Msg{}
+		m.SetQuestion(name, dns.TypeNS)
+		reply, err := w.query(ctx, m, w.resolvers)
+		if err != nil {
+			return nil, err
+		}
+		var nameservers []string
+		for _, rr := range reply.Answer {
+			if ns, ok := rr.(*dns.NS); ok {
+				nameservers = append(nameservers, ns.Ns)
+			}
+		}
+		if len(nameservers) > 0 {
+			return nameservers, nil
+		}
+		labels := dns.SplitDomainName(name)
+		if len(labels) <= 1 {
+			return nil, fmt.Errorf("no NS records found for %s", zone)
+		}
+		name = dns.Fqdn(strings.Join(labels[1:], "."))
+	}
+}
+
+// serves reports whether the nameserver ns answers authoritatively with
+// value in a TXT record at fqdn.
+func (w *propagationWaiter) serves(ctx context.Context, ns string, fqdn string, value string) (bool, error) {
+	m := &dns.Msg{}
+	m.SetQuestion(dns.Fqdn(fqdn), dns.TypeTXT)
+	m.RecursionDesired = false
+	reply, err := w.query(ctx, m, []string{net.JoinHostPort(strings.TrimSuffix(ns, "."), "53")})
+	if err != nil {
+		return false, err
+	}
+	for _, rr := range reply.Answer {
+		if txt, ok := rr.(*dns.TXT); ok && strings.Join(txt.Txt, "") == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// query sends m to the first of servers that answers it.
+func (w *propagationWaiter) query(ctx context.Context, m *dns.Msg, servers []string) (*dns.Msg, error) {
+	var err error
+	for _, server := range servers {
+		var reply *dns.Msg
+		reply, err = w.exchange(ctx, m, server)
+		if err != nil {
+			continue
+		}
+		if reply.Rcode != dns.RcodeSuccess && reply.Rcode != dns.RcodeNameError {
+			err = fmt.Errorf("%s answered %s for %s", server, dns.RcodeToString[reply.Rcode], m.Question[0].Name)
+			continue
+		}
+		return reply, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("no nameservers to query")
+	}
+	return nil, err
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeNameservers answers NS queries on the resolver and TXT queries on the
+// authoritative nameservers from in-memory data.
+type fakeNameservers struct {
+	sync.Mutex
+	ns      map[string][]string
+	txt     map[string]map[string]string
+	queries map[string]int
+}
+
+func (f *fakeNameservers) exchange(ctx context.Context, m *dns.Msg, server string) (*dns.Msg, error) {
+	f.Lock()
+	defer f.Unlock()
+	q := m.Question[0]
+	f.queries[server]++
+	reply := &dns.Msg{}
+	reply.SetReply(m)
+	hdr := dns.RR_Header{Name: q.Name, Class: dns.ClassINET, Rrtype: q.Qtype, Ttl: 60}
+	switch q.Qtype {
+	case dns.TypeNS:
+		for _, ns := range f.ns[q.Name] {
+			reply.Answer = append(reply.Answer, &dns.NS{Hdr: hdr, Ns: ns})
+		}
+	case dns.TypeTXT:
+		if value, ok := f.txt[server][q.Name]; ok {
+			reply.Answer = append(reply.Answer, &dns.TXT{Hdr: hdr, Txt: []string{value}})
+		}
+	}
+	return reply, nil
+}
+
+func newTestPropagationWaiter(f *fakeNameservers) *propagationWaiter {
+	return &propagationWaiter{
+		timeout:   time.Second,
+		interval:  time.Millisecond,
+		resolvers: []string{"resolver:53"},
+		exchange:  f.exchange,
+	}
+}
+
+func TestPropagationWaiter_Wait(t *testing.T) {
+	f := &fakeNameservers{
+		ns: map[string][]string{"example.com.": {"ns1.dynu.com.", "ns2.dynu.com."}},
+		txt: map[string]map[string]string{
+			"ns1.dynu.com:53": {"_acme-challenge.example.com.": "key1"},
+			"ns2.dynu.com:53": {},
+		},
+		queries: map[string]int{},
+	}
+	w := newTestPropagationWaiter(f)
+
+	go func() {
+		// ns2 picks up the record a little later.
+		time.Sleep(20 * time.Millisecond)
+		f.Lock()
+		f.txt["ns2.dynu.com:53"]["_acme-challenge.example.com."] = "key1"
+		f.Unlock()
+	}()
+	start := time.Now()
+	assert.NoError(t, w.Wait(context.Background(), "example.com.", "_acme-challenge.example.com.", "key1"))
+	assert.Less(t, time.Since(start), w.timeout)
+	assert.Greater(t, f.queries["ns2.dynu.com:53"], 1)
+}
+
+func TestPropagationWaiter_WaitTimeout(t *testing.T) {
+	f := &fakeNameservers{
+		ns: map[string][]string{"example.com.": {"ns1.dynu.com."}},
+		txt: map[string]map[string]string{
+			"ns1.dynu.com:53": {"_acme-challenge.example.com.": "old-key"},
+		},
+		queries: map[string]int{},
+	}
+	w := newTestPropagationWaiter(f)
+	w.timeout = 20 * time.Millisecond
+
+	// Running out of time is not an error; the self check takes over.
+	assert.NoError(t, w.Wait(context.Background(), "example.com", "_acme-challenge.example.com", "key1"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, w.Wait(ctx, "example.com", "_acme-challenge.example.com", "key1"))
+}
+
+func TestPropagationWaiter_Nameservers(t *testing.T) {
+	f := &fakeNameservers{
+		ns:      map[string][]string{"example.com.": {"ns1.dynu.com."}},
+		queries: map[string]int{},
+	}
+	w := newTestPropagationWaiter(f)
+
+	nameservers, err := w.nameservers(context.Background(), "Sub.Example.com.")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ns1.dynu.com."}, nameservers)
+
+	_, err = w.nameservers(context.Background(), "example.org")
+	assert.Error(t, err)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+
+	"github.com/Dopingus/cert-manager-webhook-dynu/dynu"
+)
+
+// idnaProfile converts names to their ASCII form for comparison. Unlike
+// idna.Lookup it accepts underscores, as in _acme-challenge.
+var idnaProfile = idna.New(idna.MapForLookup(), idna.StrictDomainName(false), idna.Transitional(false))
+
+// errAmbiguousDomain reports that a name matches several Dynu domains equally
+// well.
+var errAmbiguousDomain = errors.New("ambiguous Dynu domain")
+
+// normalizeZone returns the form in which domain names are compared: lower
+// case, in ASCII (punycode) form and without a trailing dot. Names that are
+// not valid IDNs are only lowercased.
+func normalizeZone(zone string) string {
+	zone = strings.TrimSuffix(zone, ".")
+	if ascii, err := idnaProfile.ToASCII(zone); err == nil {
+		zone = ascii
+	}
+	return strings.ToLower(zone)
+}
+
+// inZone reports whether fqdn is zone or a name below it.
+func inZone(fqdn string, zone string) bool {
+	fqdn = normalizeZone(fqdn)
+	zone = normalizeZone(zone)
+	return fqdn == zone || strings.HasSuffix(fqdn, "."+zone)
+}
+
+// recordNameInZone returns fqdn relative to zone, which fqdn must be in. The
+// labels of the record name are kept as they are in fqdn.
+func recordNameInZone(fqdn string, zone string) string {
+	labels := strings.Split(strings.TrimSuffix(fqdn, "."), ".")
+	zoneLabels := strings.Count(normalizeZone(zone), ".") + 1
+	if zoneLabels >= len(labels) {
+		return ""
+	}
+	return strings.Join(labels[:len(labels)-zoneLabels], ".")
+}
+
+// resolveDomain returns the Dynu domain serving name: the one whose name, or
+// Unicode name, is the longest suffix of name. It fails if two different
+// domains match equally well.
+func resolveDomain(domains []dynu.Domain, name string) (dynu.Domain, bool, error) {
+	var best, tie *dynu.Domain
+	bestMatch := ""
+	for i := range domains {
+		domain := &domains[i]
+		match := normalizeZone(domain.Name)
+		if !inZone(name, match) {
+			if domain.UnicodeName == "" || !inZone(name, domain.UnicodeName) {
+				continue
+			}
+			match = normalizeZone(domain.UnicodeName)
+		}
+		switch {
+		case best == nil || len(match) > len(bestMatch):
+			best, bestMatch, tie = domain, match, nil
+		case match == bestMatch && domain.Id != best.Id:
+			tie = domain
+		}
+	}
+	if best == nil {
+		return dynu.Domain{}, false, nil
+	}
+	if tie != nil {
+		return dynu.Domain{}, false, fmt.Errorf("%w: %s matches the Dynu domains %s (ID %d) and %s (ID %d) alike", errAmbiguousDomain, strings.TrimSuffix(name, "."), domainName(*best), best.Id, domainName(*tie), tie.Id)
+	}
+	return *best, true, nil
+}
+
+// domainName returns the name of domain for messages.
+func domainName(domain dynu.Domain) string {
+	if domain.Name == "" {
+		return domain.UnicodeName
+	}
+	return domain.Name
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Dopingus/cert-manager-webhook-dynu/dynu"
+)
+
+func TestNormalizeZone(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{"example.com", "example.com"},
+		{"Example.COM.", "example.com"},
+		{"_acme-challenge.Example.com.", "_acme-challenge.example.com"},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"BÜCHER.example.", "xn--bcher-kva.example"},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example"},
+		{"XN--BCHER-KVA.example", "xn--bcher-kva.example"},
+		{"", ""},
+	} {
+		assert.Equal(t, tc.want, normalizeZone(tc.name), tc.name)
+	}
+}
+
+func TestRecordNameInZone(t *testing.T) {
+	for _, tc := range []struct {
+		fqdn string
+		zone string
+		want string
+	}{
+		{"_acme-challenge.example.com.", "example.com", "_acme-challenge"},
+		{"_acme-challenge.WWW.example.com.", "Example.com.", "_acme-challenge.WWW"},
+		{"example.com.", "example.com", ""},
+		{"_acme-challenge.bücher.example.", "xn--bcher-kva.example", "_acme-challenge"},
+		{"_acme-challenge.xn--bcher-kva.example.", "bücher.example", "_acme-challenge"},
+	} {
+		assert.Equal(t, tc.want, recordNameInZone(tc.fqdn, tc.zone), tc.fqdn)
+	}
+}
+
+func TestResolveDomain(t *testing.T) {
+	domains := []dynu.Domain{
+		{Id: 1, Name: "example.com", UnicodeName: "example.com"},
+		{Id: 2, Name: "sub.example.com", UnicodeName: "sub.example.com"},
+		{Id: 3, Name: "xn--bcher-kva.example", UnicodeName: "bücher.example"},
+		{Id: 4, Name: "Example.ORG"},
+	}
+	for _, tc := range []struct {
+		name   string
+		wantId int
+	}{
+		{"_acme-challenge.example.com.", 1},
+		{"example.com", 1},
+		{"_acme-challenge.www.Sub.Example.com.", 2},
+		{"sub.example.com.", 2},
+		// Only whole labels match.
+		{"_acme-challenge.notexample.com.", 0},
+		{"_acme-challenge.bücher.example.", 3},
+		{"_acme-challenge.xn--bcher-kva.example.", 3},
+		{"_acme-challenge.BÜCHER.example", 3},
+		{"_acme-challenge.example.org.", 4},
+		{"_acme-challenge.example.net.", 0},
+	} {
+		domain, ok, err := resolveDomain(domains, tc.name)
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.wantId != 0, ok, tc.name)
+		assert.Equal(t, tc.wantId, domain.Id, tc.name)
+	}
+}
+
+func TestResolveDomain_Ambiguous(t *testing.T) {
+	domains := []dynu.Domain{
+		{Id: 1, Name: "example.com"},
+		{Id: 2, Name: "xn--bcher-kva.example"},
+		{Id: 3, UnicodeName: "bücher.example"},
+	}
+	_, _, err := resolveDomain(domains, "_acme-challenge.bücher.example.")
+	assert.ErrorIs(t, err, errAmbiguousDomain)
+	assert.ErrorContains(t, err, "_acme-challenge.bücher.example matches the Dynu domains xn--bcher-kva.example (ID 2) and bücher.example (ID 3) alike")
+
+	// A longer match settles a tie of shorter ones.
+	domains = append(domains, dynu.Domain{Id: 4, Name: "www.bücher.example"})
+	domain, ok, err := resolveDomain(domains, "_acme-challenge.www.bücher.example.")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 4, domain.Id)
+
+	// The same domain listed twice is not ambiguous.
+	domain, ok, err = resolveDomain([]dynu.Domain{{Id: 1, Name: "example.com"}, {Id: 1, Name: "Example.com"}}, "example.com")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 1, domain.Id)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// secretGetter returns Secrets by namespace and name.
+type secretGetter interface {
+	GetSecret(ctx context.Context, namespace string, name string) (*corev1.Secret, error)
+}
+
+// secretCacheSyncTimeout is how long a lookup waits for a new informer to
+// sync before it falls back to reading the Secret from the API server.
+const secretCacheSyncTimeout = 10 * time.Second
+
+// secretCacheRetryInterval is how long Secrets whose informer failed are read
+// from the API server before another informer is started for them.
+const secretCacheRetryInterval = 5 * time.Minute
+
+// secretCacheIdleTimeout is how long the informer of a Secret runs without
+// lookups before it is stopped.
+const secretCacheIdleTimeout = time.Hour
+
+// secretCache serves Secret lookups from informers, so that challenges do not
+// hit the API server for their credentials. Every referenced Secret gets its
+// own informer, filtered by name, so that the webhook only needs get, list and
+// watch permission on the Secrets it actually uses. Informers that fail, e.g.
+// because listing the Secret is forbidden, or that are no longer used are
+// stopped again.
+type secretCache struct {
+	client kubernetes.Interface
+	stopCh <-chan struct{}
+	// onRotate is called with every value that was removed from or replaced
+	// in a cached Secret, i.e. with the old API key when a key is rotated.
+	onRotate func(oldValue string)
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*secretCacheEntry
+	// failed holds when the informer of a Secret last failed.
+	failed map[string]time.Time
+}
+
+type secretCacheEntry struct {
+	lister    corelisters.SecretNamespaceLister
+	hasSynced cache.InformerSynced
+	// stop stops the informer.
+	stop chan struct{}
+	// lastUsed is the time of the last lookup, guarded by secretCache.mu.
+	lastUsed time.Time
+
+	failOnce sync.Once
+	// failed is closed once the informer failed with err.
+	failed chan struct{}
+	err    error
+}
+
+var _ secretGetter = &secretCache{}
+
+func newSecretCache(client kubernetes.Interface, stopCh <-chan struct{}, onRotate func(oldValue string)) *secretCache {
+	return &secretCache{
+		client:   client,
+		stopCh:   stopCh,
+		onRotate: onRotate,
+		now:      time.Now,
+		entries:  map[string]*secretCacheEntry{},
+		failed:   map[string]time.Time{},
+	}
+}
+
+// GetSecret returns the Secret namespace/name. The informer for it is
+// started on the first lookup and runs until stopCh is closed, the Secret is
+// not looked up for secretCacheIdleTimeout or the informer fails.
+func (s *secretCache) GetSecret(ctx context.Context, namespace string, name string) (*corev1.Secret, error) {
+	key := namespace + "/" + name
+	entry := s.entry(namespace, name)
+	if entry == nil {
+		return s.client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	}
+	if !entry.hasSynced() {
+		if err := s.waitForSync(ctx, entry); err != nil {
+			klog.Warningf("Secret cache for %s/%s failed, reading the secret from the API server: %v", namespace, name, err)
+			s.drop(key, entry, true)
+			return s.client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		}
+	}
+	return entry.lister.Get(name)
+}
+
+// entry returns the cache entry of the Secret namespace/name, starting its
+// informer if needed. It returns nil while the Secret is to be read from the
+// API server after its informer failed.
+func (s *secretCache) entry(namespace string, name string) *secretCacheEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.evictIdle(now)
+	key := namespace + "/" + name
+	if entry, ok := s.entries[key]; ok {
+		entry.lastUsed = now
+		return entry
+	}
+	if failedAt, ok := s.failed[key]; ok {
+		if now.Sub(failedAt) < secretCacheRetryInterval {
+			return nil
+		}
+		delete(s.failed, key)
+	}
+
+	informer := coreinformers.NewFilteredSecretInformer(s.client, namespace, 0, cache.Indexers{}, func(options *metav1.ListOptions) {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+	})
+	entry := &secretCacheEntry{
+		lister:    corelisters.NewSecretLister(informer.GetIndexer()).Secrets(namespace),
+		hasSynced: informer.HasSynced,
+		stop:      make(chan struct{}),
+		lastUsed:  now,
+		failed:    make(chan struct{}),
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			s.rotated(name, oldObj, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			s.rotated(name, obj, nil)
+		},
+	})
+	_ = informer.SetWatchErrorHandler(func(r *cache.Reflector, err error) {
+		cache.DefaultWatchErrorHandler(r, err)
+		// Retrying does not help until the RBAC rules change.
+		if apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err) {
+			entry.failOnce.Do(func() {
+				entry.err = err
+				close(entry.failed)
+			})
+			s.drop(key, entry, true)
+		}
+	})
+	stopCh := make(chan struct{})
+	go func() {
+		select {
+		case <-s.stopCh:
+		case <-entry.stop:
+		}
+		close(stopCh)
+	}()
+	go informer.Run(stopCh)
+
+	s.entries[key] = entry
+	return entry
+}
+
+// evictIdle stops the informers of the Secrets not looked up for
+// secretCacheIdleTimeout. s.mu must be held.
+func (s *secretCache) evictIdle(now time.Time) {
+	for key, entry := range s.entries {
+		if now.Sub(entry.lastUsed) >= secretCacheIdleTimeout {
+			klog.V(4).Infof("Secret %s was not used for %v, stopping its informer", key, secretCacheIdleTimeout)
+			delete(s.entries, key)
+			close(entry.stop)
+		}
+	}
+}
+
+// drop stops the informer of entry and removes it from the cache. With failed
+// set, the Secret is read from the API server for secretCacheRetryInterval.
+func (s *secretCache) drop(key string, entry *secretCacheEntry, failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries[key] != entry {
+		return
+	}
+	delete(s.entries, key)
+	close(entry.stop)
+	if failed {
+		s.failed[key] = s.now()
+	}
+}
+
+// waitForSync waits until the informer of entry has synced. It gives up after
+// secretCacheSyncTimeout, when ctx is done or as soon as the informer failed.
+func (s *secretCache) waitForSync(ctx context.Context, entry *secretCacheEntry) error {
+	ctx, cancel := context.WithTimeout(ctx, secretCacheSyncTimeout)
+	defer cancel()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for !entry.hasSynced() {
+		select {
+		case <-entry.failed:
+			return entry.err
+		case <-ctx.Done():
+			return fmt.Errorf("informer did not sync: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// rotated calls onRotate with every value of oldObj that newObj no longer
+// holds under the same key. newObj is nil for a deleted Secret.
+func (s *secretCache) rotated(name string, oldObj interface{}, newObj interface{}) {
+	oldSecret, ok := oldObj.(*corev1.Secret)
+	if !ok || oldSecret.Name != name || s.onRotate == nil {
+		return
+	}
+	newSecret, _ := newObj.(*corev1.Secret)
+	for key, oldValue := range oldSecret.Data {
+		if newSecret != nil {
+			if newValue, ok := newSecret.Data[key]; ok && bytes.Equal(oldValue, newValue) {
+				continue
+			}
+		}
+		klog.Infof("Value of %q in secret %s/%s changed, dropping cached Dynu data of the old value", key, oldSecret.Namespace, oldSecret.Name)
+		s.onRotate(string(oldValue))
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestSecretCache(t *testing.T) {
+	ctx := context.Background()
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cert-manager", Name: "dynu-secret"},
+		Data:       map[string][]byte{"api-key": []byte("old-key"), "other": []byte("unchanged")},
+	}
+	client := fake.NewSimpleClientset(secret)
+	// The fake clientset drops events sent before the informer watches.
+	watching := make(chan struct{})
+	client.PrependWatchReactor("secrets", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w, err := client.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		if err != nil {
+			return false, nil, err
+		}
+		if name, _ := action.(k8stesting.WatchAction).GetWatchRestrictions().Fields.RequiresExactMatch("metadata.name"); name == "dynu-secret" {
+			close(watching)
+		}
+		return true, w, nil
+	})
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	var mu sync.Mutex
+	var rotated []string
+	secrets := newSecretCache(client, stopCh, func(oldValue string) {
+		mu.Lock()
+		defer mu.Unlock()
+		rotated = append(rotated, oldValue)
+	})
+
+	apiKey, err := readApiKey(ctx, secrets, secretKeySelector{Namespace: "cert-manager", Name: "dynu-secret", Key: "api-key"})
+	assert.NoError(t, err)
+	assert.Equal(t, "old-key", apiKey)
+
+	_, err = secrets.GetSecret(ctx, "cert-manager", "missing")
+	assert.Error(t, err)
+
+	<-watching
+	secret = secret.DeepCopy()
+	secret.Data["api-key"] = []byte("new-key")
+	_, err = client.CoreV1().Secrets("cert-manager").Update(ctx, secret, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		apiKey, err := readApiKey(ctx, secrets, secretKeySelector{Namespace: "cert-manager", Name: "dynu-secret", Key: "api-key"})
+		return err == nil && apiKey == "new-key"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(rotated) == 1 && rotated[0] == "old-key"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestSecretCache_ForbiddenFallsBackFast(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team-b", Name: "dynu-secret"},
+		Data:       map[string][]byte{"api-key": []byte("key")},
+	})
+	var lists int
+	var mu sync.Mutex
+	client.PrependReactor("list", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		lists++
+		return true, nil, apierrors.NewForbidden(corev1.Resource("secrets"), "", nil)
+	})
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	secrets := newSecretCache(client, stopCh, nil)
+
+	start := time.Now()
+	secret, err := secrets.GetSecret(ctx, "team-b", "dynu-secret")
+	assert.NoError(t, err)
+	assert.Equal(t, "key", string(secret.Data["api-key"]))
+	assert.Less(t, time.Since(start), secretCacheSyncTimeout/2)
+	secrets.mu.Lock()
+	assert.Empty(t, secrets.entries)
+	secrets.mu.Unlock()
+
+	// Further lookups read the secret directly instead of starting another
+	// informer.
+	_, err = secrets.GetSecret(ctx, "team-b", "dynu-secret")
+	assert.NoError(t, err)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, lists)
+}
+
+func TestSecretCache_EvictsIdleInformers(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cert-manager", Name: "dynu-secret"},
+	}, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cert-manager", Name: "other-secret"},
+	})
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	secrets := newSecretCache(client, stopCh, nil)
+	now := time.Now()
+	secrets.now = func() time.Time { return now }
+
+	_, err := secrets.GetSecret(ctx, "cert-manager", "dynu-secret")
+	assert.NoError(t, err)
+	now = now.Add(secretCacheIdleTimeout)
+	_, err = secrets.GetSecret(ctx, "cert-manager", "other-secret")
+	assert.NoError(t, err)
+
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+	assert.Len(t, secrets.entries, 1)
+	assert.Contains(t, secrets.entries, "cert-manager/other-secret")
+}
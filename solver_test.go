@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+
+	"github.com/Dopingus/cert-manager-webhook-dynu/dynu"
+)
+
+func newTestChallenge(fqdn string, key string) *v1alpha1.ChallengeRequest {
+	return &v1alpha1.ChallengeRequest{
+		UID:          "challenge-uid",
+		Action:       v1alpha1.ChallengeActionPresent,
+		Type:         "dns-01",
+		ResolvedFQDN: fqdn,
+		Key:          key,
+	}
+}
+
+func mustPresent(t *testing.T, fake *fakeDynu, cfg dynuDNSProviderConfig, ch *v1alpha1.ChallengeRequest) challengeRecords {
+	records, err := presentTxtRecords(context.Background(), fake, cfg, ch)
+	assert.NoError(t, err)
+	return records
+}
+
+func TestPresentTxtRecords(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"})
+
+	_, err := presentTxtRecords(context.Background(), fake, dynuDNSProviderConfig{}, newTestChallenge("_acme-challenge.www.example.com.", "key1"))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"_acme-challenge.www", "www"}, fake.txtRecords(1, "key1"))
+}
+
+func TestPresentTxtRecords_FirstRecordFails(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"})
+	fake.addErr[1] = dynu.ErrValidation
+
+	_, err := presentTxtRecords(context.Background(), fake, dynuDNSProviderConfig{}, newTestChallenge("_acme-challenge.example.com.", "key1"))
+	assert.ErrorIs(t, err, dynu.ErrValidation)
+	assert.Empty(t, fake.txtRecords(1, "key1"))
+}
+
+func TestPresentTxtRecords_SecondRecordFailsRollsBack(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"})
+	fake.addErr[2] = dynu.ErrRateLimited
+
+	_, err := presentTxtRecords(context.Background(), fake, dynuDNSProviderConfig{}, newTestChallenge("_acme-challenge.example.com.", "key1"))
+	assert.ErrorIs(t, err, dynu.ErrRateLimited)
+	assert.Empty(t, fake.txtRecords(1, "key1"))
+}
+
+func TestPresentTxtRecords_RollbackFails(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"})
+	fake.addErr[2] = dynu.ErrRateLimited
+	fake.deleteErr[1] = errors.New("connection reset")
+
+	_, err := presentTxtRecords(context.Background(), fake, dynuDNSProviderConfig{}, newTestChallenge("_acme-challenge.example.com.", "key1"))
+	assert.ErrorIs(t, err, dynu.ErrRateLimited)
+	assert.Contains(t, err.Error(), "connection reset")
+}
+
+func TestCleanUpTxtRecords(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"})
+	ch := newTestChallenge("_acme-challenge.example.com.", "key1")
+	mustPresent(t, fake, dynuDNSProviderConfig{}, ch)
+
+	assert.NoError(t, cleanUpTxtRecords(context.Background(), fake, dynuDNSProviderConfig{}, ch))
+	assert.Empty(t, fake.txtRecords(1, "key1"))
+
+	// A repeated clean up finds nothing to delete.
+	assert.NoError(t, cleanUpTxtRecords(context.Background(), fake, dynuDNSProviderConfig{}, ch))
+}
+
+func TestCleanUpTxtRecords_AlreadyDeleted(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"})
+	ch := newTestChallenge("_acme-challenge.example.com.", "key1")
+	mustPresent(t, fake, dynuDNSProviderConfig{}, ch)
+	fake.deleteErr[1] = &dynu.APIError{HTTPStatus: 404, StatusCode: 404}
+
+	assert.NoError(t, cleanUpTxtRecords(context.Background(), fake, dynuDNSProviderConfig{}, ch))
+}
+
+func TestCleanUpTxtRecords_AggregatesFailures(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"})
+	ch := newTestChallenge("_acme-challenge.www.example.com.", "key1")
+	mustPresent(t, fake, dynuDNSProviderConfig{}, ch)
+	fake.deleteErr[1] = dynu.ErrRateLimited
+	fake.deleteErr[2] = dynu.ErrAuth
+
+	err := cleanUpTxtRecords(context.Background(), fake, dynuDNSProviderConfig{}, ch)
+	assert.ErrorIs(t, err, dynu.ErrRateLimited)
+	assert.ErrorIs(t, err, dynu.ErrAuth)
+	assert.Len(t, fake.txtRecords(1, "key1"), 2)
+}
+
+func TestPresentTxtRecords_Idempotent(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"})
+	ch := newTestChallenge("_acme-challenge.example.com.", "key1")
+
+	mustPresent(t, fake, dynuDNSProviderConfig{}, ch)
+	mustPresent(t, fake, dynuDNSProviderConfig{}, ch)
+	assert.ElementsMatch(t, []string{"_acme-challenge", ""}, fake.txtRecords(1, "key1"))
+	assert.Equal(t, 2, fake.adds)
+}
+
+func TestPresentTxtRecords_CompletesPartialRecords(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"})
+	fake.records[1] = []dynu.DnsRecord{{Id: 1, DomainId: 1, NodeName: "_acme-challenge", RecordType: "TXT", TextData: "key1"}}
+
+	mustPresent(t, fake, dynuDNSProviderConfig{}, newTestChallenge("_acme-challenge.example.com.", "key1"))
+	assert.ElementsMatch(t, []string{"_acme-challenge", ""}, fake.txtRecords(1, "key1"))
+	assert.Equal(t, 1, fake.adds)
+}
+
+func TestPresentTxtRecords_MirrorRecord(t *testing.T) {
+	disabled := false
+	for _, test := range []struct {
+		name     string
+		mirror   mirrorRecordConfig
+		expected []string
+	}{
+		{"default", mirrorRecordConfig{}, []string{"_acme-challenge.www", "www"}},
+		{"disabled", mirrorRecordConfig{Enabled: &disabled}, []string{"_acme-challenge.www"}},
+		{"custom name", mirrorRecordConfig{Name: "_acme-mirror"}, []string{"_acme-challenge.www", "_acme-mirror"}},
+		{"apex", mirrorRecordConfig{Name: "@"}, []string{"_acme-challenge.www", ""}},
+		{"same as challenge record", mirrorRecordConfig{Name: "_acme-challenge.www"}, []string{"_acme-challenge.www"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"})
+			cfg := dynuDNSProviderConfig{MirrorRecord: test.mirror}
+
+			mustPresent(t, fake, cfg, newTestChallenge("_acme-challenge.www.example.com.", "key1"))
+			assert.ElementsMatch(t, test.expected, fake.txtRecords(1, "key1"))
+		})
+	}
+}
+
+func TestCleanUpTxtRecords_OnlyOwnNodeNames(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"})
+	fake.records[1] = []dynu.DnsRecord{
+		{Id: 1, DomainId: 1, NodeName: "_acme-challenge.www", RecordType: "TXT", TextData: "key1"},
+		{Id: 2, DomainId: 1, NodeName: "www", RecordType: "TXT", TextData: "key1"},
+		{Id: 3, DomainId: 1, NodeName: "_acme-challenge.api", RecordType: "TXT", TextData: "key1"},
+		{Id: 4, DomainId: 1, NodeName: "_acme-challenge.www", RecordType: "TXT", TextData: "key2"},
+		{Id: 5, DomainId: 1, NodeName: "", RecordType: "TXT", TextData: "v=spf1 -all"},
+	}
+	disabled := false
+	cfg := dynuDNSProviderConfig{MirrorRecord: mirrorRecordConfig{Enabled: &disabled}}
+
+	fake.hostnameLookupErr = errors.New("lookup not supported")
+
+	assert.NoError(t, cleanUpTxtRecords(context.Background(), fake, cfg, newTestChallenge("_acme-challenge.www.example.com.", "key1")))
+	assert.Equal(t, 1, fake.deletes)
+	assert.ElementsMatch(t, []string{"www", "_acme-challenge.api"}, fake.txtRecords(1, "key1"))
+	assert.Len(t, fake.records[1], 4)
+}
+
+func TestCleanUpTxtRecords_UsesHostnameLookup(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"})
+	ch := newTestChallenge("_acme-challenge.www.example.com.", "key1")
+	mustPresent(t, fake, dynuDNSProviderConfig{}, ch)
+	fake.calls = nil
+
+	assert.NoError(t, cleanUpTxtRecords(context.Background(), fake, dynuDNSProviderConfig{}, ch))
+	assert.Empty(t, fake.txtRecords(1, "key1"))
+	assert.Contains(t, fake.calls, "GetRecordsByHostname _acme-challenge.www.example.com")
+	assert.Contains(t, fake.calls, "GetRecordsByHostname www.example.com")
+	assert.NotContains(t, fake.calls, "GetRecords 1")
+}
+
+func TestPresentTxtRecords_HostnameNotFound(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"})
+	fake.hostnameLookupErr = &dynu.APIError{HTTPStatus: http.StatusNotFound, StatusCode: http.StatusNotFound, Message: "no records"}
+
+	mustPresent(t, fake, dynuDNSProviderConfig{}, newTestChallenge("_acme-challenge.www.example.com.", "key1"))
+	assert.ElementsMatch(t, []string{"_acme-challenge.www", "www"}, fake.txtRecords(1, "key1"))
+	assert.NotContains(t, fake.calls, "GetRecords 1")
+}
+
+func TestZoneNameFromRecordName(t *testing.T) {
+	assert.Equal(t, "example.com", zoneNameFromRecordName("_acme-challenge.www.example.com.", "_acme-challenge.www"))
+	assert.Equal(t, "Example.com", zoneNameFromRecordName("_ACME-challenge.Example.com.", "_acme-challenge"))
+	assert.Equal(t, "example.com", zoneNameFromRecordName("example.com.", ""))
+	assert.Equal(t, "www.example.com", hostnameInZone("www", "example.com"))
+	assert.Equal(t, "example.com", hostnameInZone("", "example.com"))
+}
+
+func TestPresentTxtRecords_Ttl(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com", Ttl: 120})
+	cfg := dynuDNSProviderConfig{Ttl: 90}
+	ch := newTestChallenge("_acme-challenge.example.com.", "key1")
+	ch.ResolvedZone = "example.com."
+
+	mustPresent(t, fake, cfg, ch)
+	for _, r := range fake.records[1] {
+		assert.Equal(t, 90, r.Ttl)
+	}
+	assert.NotContains(t, fake.calls, "GetDomain 1")
+
+	// A TTL longer than the zone TTL is rejected before any record is written.
+	fake = newFakeDynu(dynu.Domain{Id: 1, Name: "example.com", Ttl: 120})
+	_, err := presentTxtRecords(context.Background(), fake, dynuDNSProviderConfig{Ttl: 300}, ch)
+	assert.ErrorContains(t, err, "ttl: 300 is longer than the TTL 120 of zone example.com")
+	assert.Empty(t, fake.records[1])
+
+	// A fully pinned domain needs no lookup, so the zone TTL is not checked.
+	fake = newFakeDynu(dynu.Domain{Id: 1, Name: "example.com", Ttl: 120})
+	mustPresent(t, fake, dynuDNSProviderConfig{Ttl: 300, DomainId: 1, ZoneName: "example.com"}, ch)
+	assert.NotContains(t, fake.calls, "GetDomain 1")
+}
+
+func TestOwnershipGroup(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"})
+	// A record created by hand with the same key must neither satisfy
+	// Present nor be deleted by CleanUp.
+	fake.records[1] = []dynu.DnsRecord{{Id: 1, DomainId: 1, NodeName: "_acme-challenge", RecordType: "TXT", TextData: "key1"}}
+	disabled := false
+	cfg := dynuDNSProviderConfig{Group: "cert-manager", MirrorRecord: mirrorRecordConfig{Enabled: &disabled}}
+	ch := newTestChallenge("_acme-challenge.example.com.", "key1")
+
+	mustPresent(t, fake, cfg, ch)
+	assert.Equal(t, 1, fake.adds)
+	assert.Equal(t, "cert-manager", fake.records[1][1].Group)
+
+	assert.NoError(t, cleanUpTxtRecords(context.Background(), fake, cfg, ch))
+	assert.Equal(t, []dynu.DnsRecord{{Id: 1, DomainId: 1, NodeName: "_acme-challenge", RecordType: "TXT", TextData: "key1"}}, fake.records[1])
+}
+
+func TestWithDefaults_RecordGroup(t *testing.T) {
+	c := newDynuDNSProviderSolver()
+	assert.Equal(t, "cert-manager", c.withDefaults(dynuDNSProviderConfig{}).Group)
+	assert.Equal(t, "acme", c.withDefaults(dynuDNSProviderConfig{Group: "acme"}).Group)
+}
+
+func TestCheckConfig_ApiUrl(t *testing.T) {
+	solver := newDynuDNSProviderSolver()
+	ch := newTestChallenge("_acme-challenge.example.com.", "key1")
+	assert.NoError(t, solver.checkConfig(dynuDNSProviderConfig{}, ch))
+	assert.NoError(t, solver.checkConfig(dynuDNSProviderConfig{ApiUrl: dynu.DefaultApiUrl + "/"}, ch))
+
+	cfg := dynuDNSProviderConfig{ApiUrl: "https://attacker.example.com/v2"}
+	assert.ErrorContains(t, solver.checkConfig(cfg, ch), "not allowed")
+
+	solver.options.AllowedApiUrls = []string{"http://dynu-stub.test.svc:8080/v2"}
+	assert.ErrorContains(t, solver.checkConfig(cfg, ch), "not allowed")
+	assert.NoError(t, solver.checkConfig(dynuDNSProviderConfig{ApiUrl: "http://dynu-stub.test.svc:8080/v2/"}, ch))
+}
+
+func TestCheckConfig_SecretNamespace(t *testing.T) {
+	solver := newDynuDNSProviderSolver()
+	ch := newTestChallenge("_acme-challenge.example.com.", "key1")
+	ch.ResourceNamespace = "team-a"
+
+	own := dynuDNSProviderConfig{ApiKeySecretRef: &secretKeySelector{Name: "dynu-secret", Namespace: "team-a"}}
+	assert.NoError(t, solver.checkConfig(own, ch))
+	assert.NoError(t, solver.checkConfig(dynuDNSProviderConfig{ApiKeySecretRef: &secretKeySelector{Name: "dynu-secret"}}, ch))
+
+	foreign := dynuDNSProviderConfig{ApiKeySecretRef: &secretKeySelector{Name: "dynu-secret", Namespace: "team-b"}}
+	assert.ErrorContains(t, solver.checkConfig(foreign, ch), `apiKeySecretRef.namespace "team-b"`)
+	foreignZone := dynuDNSProviderConfig{SecretRef: "dynu-secret", Zones: []zoneCredentials{{Zone: "example.org", ApiKeySecretRef: &secretKeySelector{Name: "dynu-secret", Namespace: "team-b"}}}}
+	assert.ErrorContains(t, solver.checkConfig(foreignZone, ch), `zones[0].apiKeySecretRef.namespace "team-b"`)
+
+	// Present refuses the challenge before reading the secret.
+	ch.Config = &extapi.JSON{Raw: []byte(`{"apiKeySecretRef":{"name":"dynu-secret","namespace":"team-b"}}`)}
+	assert.ErrorContains(t, solver.Present(ch), "not allowed")
+
+	solver.options.AllowCrossNamespaceSecrets = true
+	assert.NoError(t, solver.checkConfig(foreign, ch))
+	assert.NoError(t, solver.checkConfig(foreignZone, ch))
+}
+
+func TestPresentTxtRecords_PinnedDomain(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"}, dynu.Domain{Id: 2, Name: "sub.example.com"})
+	ch := newTestChallenge("_acme-challenge.www.Sub.example.com.", "key1")
+	ch.ResolvedZone = "sub.example.com."
+
+	// With both ID and name pinned, the domain is not looked up at all.
+	records := mustPresent(t, fake, dynuDNSProviderConfig{DomainId: 2, ZoneName: "sub.example.com"}, ch)
+	assert.Equal(t, 2, records.DomainId)
+	assert.ElementsMatch(t, []string{"_acme-challenge.www", "www"}, fake.txtRecords(2, "key1"))
+	for _, call := range fake.calls {
+		assert.NotContains(t, call, "GetRoot")
+		assert.NotContains(t, call, "GetDomain")
+	}
+
+	fake.calls = nil
+	records = mustPresent(t, fake, dynuDNSProviderConfig{DomainId: 2}, ch)
+	assert.Equal(t, 2, records.DomainId)
+	assert.Contains(t, fake.calls, "GetDomain 2")
+
+	records = mustPresent(t, fake, dynuDNSProviderConfig{ZoneName: "sub.example.com."}, ch)
+	assert.Equal(t, 2, records.DomainId)
+	assert.Contains(t, fake.calls, "GetDomains")
+	assert.NotContains(t, fake.calls, "GetRoot _acme-challenge.www.Sub.example.com")
+}
+
+func TestPresentTxtRecords_PinnedDomainMismatch(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"}, dynu.Domain{Id: 2, Name: "example.org"})
+
+	ch := newTestChallenge("_acme-challenge.example.org.", "key1")
+	ch.ResolvedZone = "example.org."
+	_, err := presentTxtRecords(context.Background(), fake, dynuDNSProviderConfig{DomainId: 1, ZoneName: "example.com"}, ch)
+	assert.ErrorContains(t, err, "resolved to zone example.org, but the solver config pins Dynu domain example.com (ID 1)")
+
+	// Without a resolved zone the record itself must be in the pinned domain.
+	ch.ResolvedZone = ""
+	_, err = presentTxtRecords(context.Background(), fake, dynuDNSProviderConfig{DomainId: 1}, ch)
+	assert.ErrorContains(t, err, "is not in the pinned Dynu domain example.com")
+
+	_, err = presentTxtRecords(context.Background(), fake, dynuDNSProviderConfig{ZoneName: "example.net"}, ch)
+	assert.ErrorContains(t, err, "pinned zone example.net is not a domain of the Dynu account")
+	assert.Empty(t, fake.txtRecords(1, "key1"))
+	assert.Empty(t, fake.txtRecords(2, "key1"))
+}
+
+func TestPresentTxtRecords_ResolvedZone(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"}, dynu.Domain{Id: 2, Name: "sub.example.com"})
+	ch := newTestChallenge("_acme-challenge.www.sub.example.com.", "key1")
+	ch.ResolvedZone = "Sub.Example.com."
+
+	records := mustPresent(t, fake, dynuDNSProviderConfig{}, ch)
+	assert.Equal(t, 2, records.DomainId)
+	assert.ElementsMatch(t, []string{"_acme-challenge.www", "www"}, fake.txtRecords(2, "key1"))
+	assert.Contains(t, fake.calls, "GetDomains")
+	for _, call := range fake.calls {
+		assert.NotContains(t, call, "GetRoot")
+	}
+}
+
+func TestPresentTxtRecords_ResolvedZoneMismatch(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"})
+	// The SOA lookup found a zone delegated away from Dynu.
+	ch := newTestChallenge("_acme-challenge.www.sub.example.com.", "key1")
+	ch.ResolvedZone = "sub.example.com."
+
+	_, err := presentTxtRecords(context.Background(), fake, dynuDNSProviderConfig{}, ch)
+	assert.ErrorContains(t, err, "is in zone sub.example.com according to its SOA records, but in Dynu domain example.com (ID 1)")
+	assert.Empty(t, fake.txtRecords(1, "key1"))
+}
+
+func TestPresentTxtRecords_AmbiguousDomain(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "xn--bcher-kva.example"}, dynu.Domain{Id: 2, Name: "bücher.example"})
+	ch := newTestChallenge("_acme-challenge.xn--bcher-kva.example.", "key1")
+	ch.ResolvedZone = "xn--bcher-kva.example."
+
+	_, err := presentTxtRecords(context.Background(), fake, dynuDNSProviderConfig{}, ch)
+	assert.ErrorIs(t, err, errAmbiguousDomain)
+	assert.NotContains(t, fake.calls, "GetRoot _acme-challenge.xn--bcher-kva.example")
+	assert.Empty(t, fake.txtRecords(1, "key1"))
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// challengeRecords are the Dynu records Present created for a challenge.
+type challengeRecords struct {
+	UID       string `json:"uid"`
+	FQDN      string `json:"fqdn"`
+	Key       string `json:"key"`
+	DomainId  int    `json:"domainId"`
+	RecordIds []int  `json:"recordIds"`
+	// SavedAt is when Present stored the records. Entries written by older
+	// versions have none.
+	SavedAt *time.Time `json:"savedAt,omitempty"`
+}
+
+// recordStore remembers which Dynu records belong to which challenge, so that
+// CleanUp can delete them by ID instead of searching the zone.
+type recordStore interface {
+	Save(ctx context.Context, records challengeRecords) error
+	Get(ctx context.Context, uid string) (challengeRecords, bool, error)
+	Delete(ctx context.Context, uid string) error
+	// List returns the records of all challenges.
+	List(ctx context.Context) ([]challengeRecords, error)
+	// Prune removes the records of the challenges uids at once.
+	Prune(ctx context.Context, uids []string) error
+}
+
+// configMapRecordStore is a recordStore keeping one entry per challenge UID in
+// a ConfigMap, so that it survives webhook restarts.
+type configMapRecordStore struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+var _ recordStore = &configMapRecordStore{}
+
+func newConfigMapRecordStore(client kubernetes.Interface, namespace string, name string) *configMapRecordStore {
+	return &configMapRecordStore{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+func (s *configMapRecordStore) Save(ctx context.Context, records challengeRecords) error {
+	value, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	return s.update(ctx, func(data map[string]string) {
+		data[records.UID] = string(value)
+	})
+}
+
+func (s *configMapRecordStore) Get(ctx context.Context, uid string) (challengeRecords, bool, error) {
+	records := challengeRecords{}
+	cm, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return records, false, nil
+	}
+	if err != nil {
+		return records, false, fmt.Errorf("unable to get ConfigMap %s/%s: %w", s.namespace, s.name, err)
+	}
+	value, ok := cm.Data[uid]
+	if !ok {
+		return records, false, nil
+	}
+	if err := json.Unmarshal([]byte(value), &records); err != nil {
+		return records, false, fmt.Errorf("unable to decode records of challenge %s: %w", uid, err)
+	}
+	return records, true, nil
+}
+
+func (s *configMapRecordStore) Delete(ctx context.Context, uid string) error {
+	return s.update(ctx, func(data map[string]string) {
+		delete(data, uid)
+	})
+}
+
+func (s *configMapRecordStore) List(ctx context.Context) ([]challengeRecords, error) {
+	cm, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to get ConfigMap %s/%s: %w", s.namespace, s.name, err)
+	}
+	var list []challengeRecords
+	for uid, value := range cm.Data {
+		records := challengeRecords{}
+		if err := json.Unmarshal([]byte(value), &records); err != nil {
+			// Keep undecodable entries listed, so that they can be pruned.
+			records = challengeRecords{}
+		}
+		records.UID = uid
+		list = append(list, records)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].UID < list[j].UID })
+	return list, nil
+}
+
+func (s *configMapRecordStore) Prune(ctx context.Context, uids []string) error {
+	if len(uids) == 0 {
+		return nil
+	}
+	return s.update(ctx, func(data map[string]string) {
+		for _, uid := range uids {
+			delete(data, uid)
+		}
+	})
+}
+
+// update applies mutate to the ConfigMap data, creating the ConfigMap if it
+// does not exist yet and retrying on conflicting writes.
+func (s *configMapRecordStore) update(ctx context.Context, mutate func(data map[string]string)) error {
+	configMaps := s.client.CoreV1().ConfigMaps(s.namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(ctx, s.name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      s.name,
+					Namespace: s.namespace,
+				},
+				Data: map[string]string{},
+			}
+			mutate(cm.Data)
+			_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// Lost the race against another writer; retry as an update.
+				return apierrors.NewConflict(corev1.Resource("configmaps"), s.name, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		mutate(cm.Data)
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("unable to update ConfigMap %s/%s: %w", s.namespace, s.name, err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/Dopingus/cert-manager-webhook-dynu/dynu"
+)
+
+func TestConfigMapRecordStore(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	store := newConfigMapRecordStore(client, "cert-manager", "dynu-webhook-records")
+
+	_, ok, err := store.Get(ctx, "uid-1")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	records := challengeRecords{UID: "uid-1", FQDN: "_acme-challenge.example.com.", Key: "key1", DomainId: 1, RecordIds: []int{101, 102}}
+	assert.NoError(t, store.Save(ctx, records))
+	assert.NoError(t, store.Save(ctx, challengeRecords{UID: "uid-2", Key: "key2"}))
+
+	// A new store, e.g. after a restart, sees the same records.
+	got, ok, err := newConfigMapRecordStore(client, "cert-manager", "dynu-webhook-records").Get(ctx, "uid-1")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, records, got)
+
+	assert.NoError(t, store.Delete(ctx, "uid-1"))
+	_, ok, err = store.Get(ctx, "uid-1")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	cm, err := client.CoreV1().ConfigMaps("cert-manager").Get(ctx, "dynu-webhook-records", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, cm.Data, 1)
+}
+
+func TestDeleteStoredRecords(t *testing.T) {
+	fake := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"})
+	records := mustPresent(t, fake, dynuDNSProviderConfig{}, newTestChallenge("_acme-challenge.example.com.", "key1"))
+	assert.Len(t, records.RecordIds, 2)
+	// One record is already gone.
+	assert.NoError(t, fake.DeleteRecord(context.Background(), 1, records.RecordIds[0]))
+	fake.calls = nil
+
+	assert.NoError(t, deleteStoredRecords(context.Background(), fake, records))
+	assert.Empty(t, fake.records[1])
+	assert.NotContains(t, fake.calls, "GetRecords 1")
+}
+
+func TestCleanUp_RemovesStoreEntryAfterZoneSearch(t *testing.T) {
+	ctx := context.Background()
+	fakeDynu := newFakeDynu(dynu.Domain{Id: 1, Name: "example.com"})
+	solver := newDynuDNSProviderSolver()
+	solver.ambient = &ambientCredentials{value: "ambient-key"}
+	solver.newDynuClient = func(apiKey string, opts ...dynu.Option) dynu.Interface { return fakeDynu }
+	solver.store = newConfigMapRecordStore(fake.NewSimpleClientset(), "cert-manager", "dynu-webhook-records")
+
+	ch := newTestChallenge("_acme-challenge.example.com.", "key1")
+	ch.AllowAmbientCredentials = true
+	mustPresent(t, fakeDynu, solver.withDefaults(dynuDNSProviderConfig{}), ch)
+	// The stored entry does not match the challenge key, so CleanUp searches
+	// the zone instead.
+	assert.NoError(t, solver.store.Save(ctx, challengeRecords{UID: string(ch.UID), Key: "other-key"}))
+
+	assert.NoError(t, solver.CleanUp(ch))
+	assert.Empty(t, fakeDynu.txtRecords(1, "key1"))
+	_, ok, err := solver.store.Get(ctx, string(ch.UID))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/cert-manager/cert-manager/pkg/issuer/acme/dns/util"
+	"k8s.io/klog"
+
+	"github.com/Dopingus/cert-manager-webhook-dynu/dynu"
+)
+
+// zoneCredentials maps a zone to the secret holding the API key of the Dynu
+// account serving it, so that one issuer can solve challenges for domains of
+// several accounts.
+type zoneCredentials struct {
+	// Zone is the domain suffix the entry applies to, e.g. example.com. It
+	// matches the domain itself and all names below it.
+	Zone string `json:"zone"`
+	// SecretRef is the name of the secret holding the API key under api-key
+	// in the challenge's namespace.
+	SecretRef string `json:"secretName,omitempty"`
+	// ApiKeySecretRef references the secret key holding the API key. It
+	// replaces SecretRef.
+	ApiKeySecretRef *secretKeySelector `json:"apiKeySecretRef,omitempty"`
+}
+
+// forFQDN returns cfg with the credentials of the zones entry matching fqdn
+// with the longest suffix. Without a matching entry the credentials of cfg
+// itself apply.
+func (cfg dynuDNSProviderConfig) forFQDN(fqdn string) dynuDNSProviderConfig {
+	best := -1
+	for i, zone := range cfg.Zones {
+		if inZone(fqdn, zone.Zone) && (best < 0 || len(normalizeZone(zone.Zone)) > len(normalizeZone(cfg.Zones[best].Zone))) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return cfg
+	}
+	zone := cfg.Zones[best]
+	klog.V(4).Infof("Using the credentials of zone %s for %s", zone.Zone, fqdn)
+	cfg.SecretRef = zone.SecretRef
+	cfg.ApiKeySecretRef = zone.ApiKeySecretRef
+	return cfg
+}
+
+// validateZones adds the problems of the zones entries of the solver config
+// to problems.
+func validateZones(problems *configErrors, zones []zoneCredentials) {
+	seen := map[string]bool{}
+	for i, zone := range zones {
+		prefix := fmt.Sprintf("zones[%d].", i)
+		name := normalizeZone(zone.Zone)
+		switch {
+		case name == "":
+			problems.add(prefix+"zone", "is required")
+		case strings.ContainsAny(name, " \t*"):
+			problems.add(prefix+"zone", "%q must be a domain name", zone.Zone)
+		case seen[name]:
+			problems.add(prefix+"zone", "%s is listed more than once", zone.Zone)
+		}
+		seen[name] = true
+		if zone.SecretRef == "" && zone.ApiKeySecretRef == nil {
+			problems.add(prefix+"secretName", "secretName or apiKeySecretRef is required")
+		}
+		validateSecretRef(problems, prefix, zone.SecretRef, zone.ApiKeySecretRef)
+	}
+}
+
+// domainForChallenge returns the Dynu domain and the record name relative to
+// the domain of the challenge record. A domain pinned in cfg is used as is, so
+// that no discovery calls are made. Otherwise the zone cert-manager resolved
+// from the SOA records is mapped to a Dynu domain, and only if no domain
+// matches it is the domain discovered from the challenge FQDN. Only the ID
+// and name of the domain are always set; its TTL is 0 unless it was looked up
+// anyway.
+func domainForChallenge(ctx context.Context, dynuClient dynu.Interface, cfg dynuDNSProviderConfig, ch *v1alpha1.ChallengeRequest) (dynu.Domain, string, error) {
+	if cfg.DomainId != 0 || cfg.ZoneName != "" {
+		return pinnedDomainForChallenge(ctx, dynuClient, cfg, ch)
+	}
+
+	if ch.ResolvedZone != "" {
+		domain, ok, err := domainForZone(ctx, dynuClient, ch.ResolvedZone)
+		if errors.Is(err, errAmbiguousDomain) {
+			return dynu.Domain{}, "", err
+		} else if err != nil {
+			klog.Errorf("Unable to map zone %s to a Dynu domain, discovering the domain instead: %v", ch.ResolvedZone, err)
+		} else if ok {
+			if !inZone(ch.ResolvedFQDN, domain.Name) {
+				return dynu.Domain{}, "", fmt.Errorf("challenge record %s is not in its resolved zone %s", ch.ResolvedFQDN, util.UnFqdn(ch.ResolvedZone))
+			}
+			recordName := recordNameInZone(ch.ResolvedFQDN, domain.Name)
+			klog.Infof("Resolved zone %s is Dynu domain %s (ID %d), record name %q", ch.ResolvedZone, domain.Name, domain.Id, recordName)
+			return domain, recordName, nil
+		} else {
+			klog.Infof("No Dynu domain matches resolved zone %s, discovering the domain instead", ch.ResolvedZone)
+		}
+	}
+
+	domainId, recordName, err := getDomainIdFromFQDN(ctx, dynuClient, ch.ResolvedFQDN)
+	if err != nil {
+		return dynu.Domain{}, "", err
+	}
+	zoneName := zoneNameFromRecordName(ch.ResolvedFQDN, recordName)
+	if ch.ResolvedZone != "" {
+		if normalizeZone(zoneName) != normalizeZone(ch.ResolvedZone) {
+			return dynu.Domain{}, "", fmt.Errorf("challenge record %s is in zone %s according to its SOA records, but in Dynu domain %s (ID %d); a record written to Dynu would not be served for %s", ch.ResolvedFQDN, util.UnFqdn(ch.ResolvedZone), zoneName, domainId, util.UnFqdn(ch.ResolvedZone))
+		}
+	}
+	return dynu.Domain{Id: domainId, Name: zoneName}, recordName, nil
+}
+
+// pinnedDomainForChallenge returns the Dynu domain and record name of a
+// challenge for the domain pinned in cfg.
+func pinnedDomainForChallenge(ctx context.Context, dynuClient dynu.Interface, cfg dynuDNSProviderConfig, ch *v1alpha1.ChallengeRequest) (dynu.Domain, string, error) {
+	domain := dynu.Domain{Id: cfg.DomainId, Name: normalizeZone(cfg.ZoneName)}
+	if domain.Name == "" || domain.Id == 0 {
+		var err error
+		domain, err = pinnedDomain(ctx, dynuClient, domain.Id, domain.Name)
+		if err != nil {
+			return dynu.Domain{}, "", err
+		}
+	}
+	domainId, zoneName := domain.Id, normalizeZone(domain.Name)
+	if ch.ResolvedZone != "" && normalizeZone(ch.ResolvedZone) != zoneName {
+		return dynu.Domain{}, "", fmt.Errorf("challenge for %s resolved to zone %s, but the solver config pins Dynu domain %s (ID %d); check the issuer's selector or the pinned domain", ch.ResolvedFQDN, util.UnFqdn(ch.ResolvedZone), zoneName, domainId)
+	}
+	if !inZone(ch.ResolvedFQDN, zoneName) {
+		return dynu.Domain{}, "", fmt.Errorf("challenge record %s is not in the pinned Dynu domain %s (ID %d)", ch.ResolvedFQDN, zoneName, domainId)
+	}
+	recordName := recordNameInZone(ch.ResolvedFQDN, zoneName)
+	klog.Infof("Using pinned Dynu domain %s (ID %d), record name %q", zoneName, domainId, recordName)
+	return domain, recordName, nil
+}
+
+// domainForZone returns the Dynu domain named zone.
+func domainForZone(ctx context.Context, dynuClient dynu.Interface, zone string) (dynu.Domain, bool, error) {
+	domains, err := dynuClient.GetDomains(ctx)
+	if err != nil {
+		return dynu.Domain{}, false, fmt.Errorf("unable to get domains: %w", err)
+	}
+	domain, ok, err := resolveDomain(domains, zone)
+	if err != nil || !ok {
+		return domain, false, err
+	}
+	// A domain above zone does not serve it.
+	if normalizeZone(domain.Name) != normalizeZone(zone) && normalizeZone(domain.UnicodeName) != normalizeZone(zone) {
+		return dynu.Domain{}, false, nil
+	}
+	return domain, true, nil
+}
+
+// pinnedDomain looks up the Dynu domain pinned by only its ID or only its
+// name.
+func pinnedDomain(ctx context.Context, dynuClient dynu.Interface, domainId int, zoneName string) (dynu.Domain, error) {
+	if domainId != 0 {
+		domain, err := dynuClient.GetDomain(ctx, domainId)
+		if err != nil {
+			return domain, fmt.Errorf("unable to get the pinned Dynu domain %d: %w", domainId, err)
+		}
+		return domain, nil
+	}
+	domain, ok, err := domainForZone(ctx, dynuClient, zoneName)
+	if err != nil {
+		return domain, err
+	}
+	if !ok {
+		return domain, fmt.Errorf("pinned zone %s is not a domain of the Dynu account", zoneName)
+	}
+	return domain, nil
+}